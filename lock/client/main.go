@@ -12,30 +12,202 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// The client sends a GET request and reads nothing from the response, causing a
-// poorly implemented server to block.
+// The client generates load against the server. Slow clients misbehave in one
+// of the ways provided by the rawhttp package, by default sending a GET
+// request and reading the response slowly, or not at all, causing a poorly
+// implemented server to block. Meanwhile, fast clients send requests in a loop
+// and report how long they took, making any head-of-line blocking visible.
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
+	"math"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gobuildit/gobuildit/lock/rawhttp"
 )
 
+// errorBackoff is how long a fast client pauses after a failed request, so
+// that a server that is down or refusing connections is not flooded.
+const errorBackoff = 100 * time.Millisecond
+
 func main() {
-	println("dialing")
-	conn, err := net.Dial("tcp", "localhost:8080")
-	if err != nil {
-		log.Fatal(err)
+	var (
+		addr       = flag.String("addr", "localhost:8080", "address of the server")
+		path       = flag.String("path", "/", "path to request")
+		slow       = flag.Int("slow", 1, "number of slow clients")
+		scenario   = flag.String("scenario", "slow-read", "how slow clients misbehave: "+strings.Join(rawhttp.Names(), ", "))
+		fast       = flag.Int("fast", 0, "number of fast clients")
+		readRate   = flag.Int("read-rate", 0, "bytes per second each slow reader reads, 0 to never read")
+		readBuffer = flag.Int("read-buffer", 0, "receive buffer size of each slow client in bytes, 0 for the system default")
+		interval   = flag.Duration("interval", time.Second, "pause between the header lines sent by a slowloris client")
+		requests   = flag.Int("requests", 10, "number of requests each pipelined client sends")
+		delay      = flag.Duration("delay", 500*time.Millisecond, "how long to wait after connecting the slow clients before starting the fast clients")
+		timeout    = flag.Duration("timeout", 5*time.Second, "timeout for each request by a fast client")
+		duration   = flag.Duration("duration", 0, "how long to run, 0 to run until interrupted")
+	)
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
 	}
-	println("sending GET request")
-	_, err = conn.Write([]byte("GET / HTTP/1.1\r\n"))
-	if err != nil {
-		log.Fatal(err)
+
+	sc, ok := rawhttp.Lookup(*scenario)
+	if !ok {
+		log.Fatalf("unknown scenario %q", *scenario)
+	}
+	opts := rawhttp.Options{
+		Path:       *path,
+		Interval:   *interval,
+		ReadRate:   *readRate,
+		ReadBuffer: *readBuffer,
+		Requests:   *requests,
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < *slow; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := sc.Run(ctx, *addr, opts)
+			if err != nil {
+				log.Printf("%s: %v", sc.Name, err)
+				return
+			}
+			log.Printf("%s: %v", sc.Name, res)
+		}()
 	}
-	_, err = conn.Write([]byte("Host: localhost\r\n\r\n"))
+	log.Printf("started %d %s clients", *slow, sc.Name)
+
+	var results results
+	if *fast > 0 {
+		select {
+		case <-time.After(*delay):
+		case <-ctx.Done():
+		}
+		client := &http.Client{
+			Timeout:   *timeout,
+			Transport: &http.Transport{MaxIdleConnsPerHost: *fast},
+		}
+		url := "http://" + *addr + *path
+		for i := 0; i < *fast; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					res := fetch(ctx, client, url)
+					if ctx.Err() != nil {
+						// The run ended while the request was in flight.
+						return
+					}
+					results.add(res)
+					if res.err != nil {
+						select {
+						case <-time.After(errorBackoff):
+						case <-ctx.Done():
+						}
+					}
+				}
+			}()
+		}
+		log.Printf("started %d fast clients", *fast)
+	}
+
+	wg.Wait()
+	if *fast > 0 {
+		results.print(os.Stdout)
+	}
+}
+
+// A result is the outcome of one request by a fast client.
+type result struct {
+	latency time.Duration
+	err     error
+}
+
+// fetch requests url and reads the whole response.
+func fetch(ctx context.Context, client *http.Client, url string) result {
+	start := time.Now()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		log.Fatal(err)
+		return result{err: err}
+	}
+	resp, err := client.Do(req)
+	if err == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if err == nil && resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("unexpected status %s", resp.Status)
+		}
+	}
+	return result{latency: time.Since(start), err: err}
+}
+
+// results collects the outcomes of requests by the fast clients. It is safe
+// for concurrent use.
+type results struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	timeouts  int
+	errors    map[string]int
+}
+
+func (r *results) add(res result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var ne net.Error
+	switch {
+	case res.err == nil:
+		r.latencies = append(r.latencies, res.latency)
+	case errors.As(res.err, &ne) && ne.Timeout(), errors.Is(res.err, context.DeadlineExceeded):
+		r.timeouts++
+	default:
+		if r.errors == nil {
+			r.errors = make(map[string]int)
+		}
+		r.errors[res.err.Error()]++
 	}
-	println("blocking and never reading")
-	select {}
+}
+
+// print writes a summary of the results to w.
+func (r *results) print(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	slices.Sort(r.latencies)
+	n := len(r.latencies) + r.timeouts
+	for _, c := range r.errors {
+		n += c
+	}
+	fmt.Fprintf(w, "requests: %d\ncompleted: %d\ntimeouts: %d\n", n, len(r.latencies), r.timeouts)
+	for msg, c := range r.errors {
+		fmt.Fprintf(w, "error: %s (%d)\n", msg, c)
+	}
+	if len(r.latencies) == 0 {
+		return
+	}
+	for _, p := range []float64{50, 90, 99} {
+		fmt.Fprintf(w, "p%g: %v\n", p, percentile(r.latencies, p))
+	}
+	fmt.Fprintf(w, "max: %v\n", r.latencies[len(r.latencies)-1])
+}
+
+// percentile returns the pth percentile of sorted, using the nearest rank.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(float64(len(sorted))*p/100)) - 1
+	return sorted[min(max(i, 0), len(sorted)-1)]
 }
@@ -0,0 +1,42 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	seven := []time.Duration{1, 2, 3, 4, 5, 6, 7}
+	tests := []struct {
+		sorted []time.Duration
+		p      float64
+		want   time.Duration
+	}{
+		{sorted: seven, p: 50, want: 4},
+		{sorted: seven, p: 90, want: 7},
+		{sorted: seven, p: 99, want: 7},
+		{sorted: seven, p: 1, want: 1},
+		{sorted: []time.Duration{1, 2, 3, 4}, p: 50, want: 2},
+		{sorted: []time.Duration{1, 2, 3, 4}, p: 75, want: 3},
+		{sorted: []time.Duration{5}, p: 99, want: 5},
+	}
+	for _, tc := range tests {
+		if got := percentile(tc.sorted, tc.p); got != tc.want {
+			t.Errorf("p%g of %v: want %v, got %v", tc.p, tc.sorted, tc.want, got)
+		}
+	}
+}
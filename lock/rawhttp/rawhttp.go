@@ -0,0 +1,301 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rawhttp writes HTTP/1.1 traffic byte for byte, for testing how
+// servers cope with misbehaving clients. Each kind of misbehavior is a named
+// Scenario run over its own connection.
+package rawhttp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Options configures a scenario. The zero value of each field selects a
+// default.
+type Options struct {
+	// Host is sent in the Host header. It defaults to the address dialed.
+	Host string
+	// Path is the request target. It defaults to "/".
+	Path string
+	// Interval is the pause between the pieces of a slow request. It
+	// defaults to one second.
+	Interval time.Duration
+	// ReadRate is the number of bytes per second read from the response by
+	// a slow reader. Zero means never read.
+	ReadRate int
+	// ReadBuffer sets the size of the connection's receive buffer, so that
+	// a server writing to a slow reader blocks sooner. Zero leaves the
+	// system default.
+	ReadBuffer int
+	// Requests is the number of pipelined requests. It defaults to ten.
+	Requests int
+}
+
+// A Result describes what happened to a connection during a scenario.
+type Result struct {
+	// Statuses holds the status code of each response read, in order.
+	Statuses []int
+	// BytesRead counts the bytes read from the connection.
+	BytesRead int64
+	// Closed reports whether the server closed the connection, as opposed
+	// to the scenario finishing or its context being done.
+	Closed bool
+	// Elapsed is how long the scenario ran.
+	Elapsed time.Duration
+}
+
+func (r *Result) String() string {
+	closed := "client closed the connection"
+	if r.Closed {
+		closed = "server closed the connection"
+	}
+	return fmt.Sprintf("%s after %v, read %d bytes, statuses %v", closed, r.Elapsed.Round(time.Millisecond), r.BytesRead, r.Statuses)
+}
+
+// A Scenario is a named way of misbehaving as an HTTP/1.1 client.
+type Scenario struct {
+	Name        string
+	Description string
+
+	run func(ctx context.Context, c *conn, o Options) error
+}
+
+// Scenarios lists every scenario.
+var Scenarios = []Scenario{
+	{
+		Name:        "slowloris",
+		Description: "send the request headers one line per interval, never finishing them",
+		run:         slowloris,
+	},
+	{
+		Name:        "slow-read",
+		Description: "send a request and read the response at the read rate, or never",
+		run:         slowRead,
+	},
+	{
+		Name:        "pipelined",
+		Description: "send several requests in one write before reading any response",
+		run:         pipelined,
+	},
+	{
+		Name:        "malformed-chunked",
+		Description: "send a request body with an invalid chunked transfer encoding",
+		run:         malformedChunked,
+	},
+}
+
+// Lookup returns the scenario with the given name.
+func Lookup(name string) (Scenario, bool) {
+	for _, s := range Scenarios {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Scenario{}, false
+}
+
+// Names returns the names of every scenario.
+func Names() []string {
+	names := make([]string, len(Scenarios))
+	for i, s := range Scenarios {
+		names[i] = s.Name
+	}
+	return names
+}
+
+// Run dials addr and runs the scenario until it finishes, the server closes
+// the connection, or ctx is done. The returned Result is valid even when the
+// error is not nil.
+func (s Scenario) Run(ctx context.Context, addr string, o Options) (*Result, error) {
+	if o.Host == "" {
+		o.Host = addr
+	}
+	if o.Path == "" {
+		o.Path = "/"
+	}
+	if o.Interval <= 0 {
+		o.Interval = time.Second
+	}
+	if o.Requests <= 0 {
+		o.Requests = 10
+	}
+
+	res := &Result{}
+	start := time.Now()
+	defer func() { res.Elapsed = time.Since(start) }()
+
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return res, err
+	}
+	defer nc.Close()
+	if tc, ok := nc.(*net.TCPConn); ok && o.ReadBuffer > 0 {
+		if err := tc.SetReadBuffer(o.ReadBuffer); err != nil {
+			return res, err
+		}
+	}
+	// Unblock any read or write in progress once ctx is done.
+	stop := context.AfterFunc(ctx, func() { nc.Close() })
+	defer stop()
+
+	c := &conn{Conn: nc, res: res}
+	c.r = bufio.NewReader(readCounter{c})
+	err = s.run(ctx, c, o)
+	switch {
+	case ctx.Err() != nil:
+		return res, nil
+	case closedByPeer(err):
+		res.Closed = true
+		return res, nil
+	}
+	return res, err
+}
+
+// conn is a connection being used by a scenario.
+type conn struct {
+	net.Conn
+	r   *bufio.Reader
+	res *Result
+}
+
+// readCounter counts the bytes read from a conn.
+type readCounter struct{ c *conn }
+
+func (rc readCounter) Read(b []byte) (int, error) {
+	n, err := rc.c.Conn.Read(b)
+	rc.c.res.BytesRead += int64(n)
+	return n, err
+}
+
+// readResponse reads a response and discards its body.
+func (c *conn) readResponse(method string) error {
+	resp, err := http.ReadResponse(c.r, &http.Request{Method: method})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	c.res.Statuses = append(c.res.Statuses, resp.StatusCode)
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
+
+// closedByPeer reports whether err means the server closed the connection.
+func closedByPeer(err error) bool {
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// sleep pauses for d, returning early with an error if ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// slowloris ties up a connection by sending request headers slowly and never
+// finishing them. A server without a read timeout keeps the connection open
+// indefinitely.
+func slowloris(ctx context.Context, c *conn, o Options) error {
+	if _, err := fmt.Fprintf(c, "GET %s HTTP/1.1\r\nHost: %s\r\n", o.Path, o.Host); err != nil {
+		return err
+	}
+	for i := 0; ; i++ {
+		if err := sleep(ctx, o.Interval); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(c, "X-Slowloris-%d: %d\r\n", i, i); err != nil {
+			return err
+		}
+	}
+}
+
+// slowRead sends a request and reads the response at o.ReadRate bytes per
+// second, or never if the rate is zero. A server that holds a lock while
+// writing the response holds it for as long as the read takes.
+func slowRead(ctx context.Context, c *conn, o Options) error {
+	_, err := fmt.Fprintf(c, "GET %s HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", o.Path, o.Host)
+	if err != nil {
+		return err
+	}
+	if o.ReadRate <= 0 {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	// Read a tenth of the rate every tenth of a second.
+	c.r = bufio.NewReader(&slowReader{ctx: ctx, r: readCounter{c}, n: max(o.ReadRate/10, 1)})
+	return c.readResponse(http.MethodGet)
+}
+
+// slowReader reads at most n bytes from r every tenth of a second.
+type slowReader struct {
+	ctx context.Context
+	r   io.Reader
+	n   int
+}
+
+func (sr *slowReader) Read(b []byte) (int, error) {
+	if err := sleep(sr.ctx, 100*time.Millisecond); err != nil {
+		return 0, err
+	}
+	if len(b) > sr.n {
+		b = b[:sr.n]
+	}
+	return sr.r.Read(b)
+}
+
+// pipelined sends o.Requests requests in a single write and then reads the
+// responses, which the server must send in order.
+func pipelined(ctx context.Context, c *conn, o Options) error {
+	req := fmt.Sprintf("GET %s HTTP/1.1\r\nHost: %s\r\n\r\n", o.Path, o.Host)
+	if _, err := io.WriteString(c, strings.Repeat(req, o.Requests)); err != nil {
+		return err
+	}
+	for i := 0; i < o.Requests; i++ {
+		if err := c.readResponse(http.MethodGet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// malformedChunked sends a body whose chunk size is not hexadecimal and reads
+// the response. A server that reads the body should reject it with 400 Bad
+// Request; one that ignores the body may respond as usual and then close the
+// connection.
+func malformedChunked(ctx context.Context, c *conn, o Options) error {
+	_, err := fmt.Fprintf(c, "POST %s HTTP/1.1\r\nHost: %s\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"zz\r\nnot a chunk\r\n0\r\n\r\n", o.Path, o.Host)
+	if err != nil {
+		return err
+	}
+	return c.readResponse(http.MethodPost)
+}
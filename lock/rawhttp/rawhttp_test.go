@@ -0,0 +1,113 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rawhttp
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// echo responds with the request body, or 400 Bad Request if it cannot be read.
+func echo(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Write(b)
+}
+
+func run(t *testing.T, ts *httptest.Server, name string, o Options) *Result {
+	t.Helper()
+	s, ok := Lookup(name)
+	if !ok {
+		t.Fatalf("no scenario %q", name)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := s.Run(ctx, ts.Listener.Addr().String(), o)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return res
+}
+
+func TestPipelined(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(echo))
+	defer ts.Close()
+
+	res := run(t, ts, "pipelined", Options{Requests: 3})
+	if want := []int{200, 200, 200}; !reflect.DeepEqual(res.Statuses, want) {
+		t.Fatalf("want statuses %v, got %v", want, res.Statuses)
+	}
+}
+
+func TestMalformedChunked(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(echo))
+	defer ts.Close()
+
+	res := run(t, ts, "malformed-chunked", Options{})
+	if want := []int{http.StatusBadRequest}; !reflect.DeepEqual(res.Statuses, want) {
+		t.Fatalf("want statuses %v, got %v", want, res.Statuses)
+	}
+}
+
+func TestSlowloris(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(echo))
+	ts.Config.ReadHeaderTimeout = 200 * time.Millisecond
+	ts.Start()
+	defer ts.Close()
+
+	res := run(t, ts, "slowloris", Options{Interval: 50 * time.Millisecond})
+	if !res.Closed {
+		t.Fatalf("want server to close the connection, got %v", res)
+	}
+}
+
+func TestSlowRead(t *testing.T) {
+	body := make([]byte, 1000)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer ts.Close()
+
+	res := run(t, ts, "slow-read", Options{ReadRate: 10000})
+	if res.Closed || res.BytesRead < int64(len(body)) {
+		t.Fatalf("want the whole response read, got %v", res)
+	}
+	if want := []int{200}; !reflect.DeepEqual(res.Statuses, want) {
+		t.Fatalf("want statuses %v, got %v", want, res.Statuses)
+	}
+}
+
+func TestSlowReadClosed(t *testing.T) {
+	// The server hangs up partway through the body.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1000")
+		w.Write(make([]byte, 10))
+		panic(http.ErrAbortHandler)
+	}))
+	defer ts.Close()
+
+	res := run(t, ts, "slow-read", Options{ReadRate: 10000})
+	if !res.Closed {
+		t.Fatalf("want server to close the connection, got %v", res)
+	}
+}
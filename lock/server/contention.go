@@ -0,0 +1,214 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// eventInterval is how often /debug/contention/events sends an update.
+const eventInterval = 500 * time.Millisecond
+
+// A flight is a request waiting for or holding a handler's lock.
+type flight struct {
+	handler string
+	remote  string
+	path    string
+	start   time.Time
+	// acquired is when the request acquired the lock, or zero while it is
+	// still waiting.
+	acquired time.Time
+}
+
+// flights tracks the requests waiting for or holding locks. It is safe for
+// concurrent use.
+type flights struct {
+	mu   sync.Mutex
+	next uint64
+	m    map[uint64]*flight
+}
+
+// add starts tracking f and returns its id.
+func (fs *flights) add(f *flight) uint64 {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.m == nil {
+		fs.m = make(map[uint64]*flight)
+	}
+	fs.next++
+	fs.m[fs.next] = f
+	return fs.next
+}
+
+// acquire records that the flight with the given id acquired its lock at t.
+func (fs *flights) acquire(id uint64, t time.Time) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.m[id].acquired = t
+}
+
+// remove stops tracking the flight with the given id.
+func (fs *flights) remove(id uint64) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	delete(fs.m, id)
+}
+
+// flightState is the JSON form of a flight sent to the contention page.
+type flightState struct {
+	Handler string `json:"handler"`
+	Remote  string `json:"remote"`
+	Path    string `json:"path"`
+	Holding bool   `json:"holding"`
+	// WaitedMillis is how long the request waited for the lock, so far if
+	// it is still waiting. HeldMillis is how long it has held the lock.
+	WaitedMillis float64 `json:"waited_ms"`
+	HeldMillis   float64 `json:"held_ms"`
+}
+
+// snapshot returns the state of every flight at now, grouped by handler and
+// oldest first.
+func (fs *flights) snapshot(now time.Time) []flightState {
+	fs.mu.Lock()
+	all := make([]flight, 0, len(fs.m))
+	for _, f := range fs.m {
+		all = append(all, *f)
+	}
+	fs.mu.Unlock()
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].handler != all[j].handler {
+			return all[i].handler < all[j].handler
+		}
+		return all[i].start.Before(all[j].start)
+	})
+	states := make([]flightState, len(all))
+	for i, f := range all {
+		s := flightState{
+			Handler: f.handler,
+			Remote:  f.remote,
+			Path:    f.path,
+			Holding: !f.acquired.IsZero(),
+		}
+		if s.Holding {
+			s.WaitedMillis = millis(f.acquired.Sub(f.start))
+			s.HeldMillis = millis(now.Sub(f.acquired))
+		} else {
+			s.WaitedMillis = millis(now.Sub(f.start))
+		}
+		states[i] = s
+	}
+	return states
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// contention serves a page listing the requests waiting for or holding each
+// lock, kept up to date by /debug/contention/events.
+func (s *server) contention(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, contentionPage)
+}
+
+// contentionEvents streams snapshots of the in-flight requests as Server-Sent
+// Events until the client goes away.
+func (s *server) contentionEvents(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	// The stream is meant to stay open, so lift any write deadline.
+	rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	ticker := time.NewTicker(eventInterval)
+	defer ticker.Stop()
+	for {
+		b, err := json.Marshal(s.flights.snapshot(time.Now()))
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", b); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+const contentionPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Lock contention</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { padding: 4px 12px; text-align: left; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; }
+tr.holding { background: #fdd; font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>Lock contention</h1>
+<p id="status">Connecting…</p>
+<table>
+<thead>
+<tr><th>Handler</th><th>State</th><th>Client</th><th>Path</th><th>Waited (ms)</th><th>Held (ms)</th></tr>
+</thead>
+<tbody id="flights"></tbody>
+</table>
+<script>
+const status = document.getElementById("status");
+const tbody = document.getElementById("flights");
+const events = new EventSource("/debug/contention/events");
+events.onopen = () => { status.textContent = "Live"; };
+events.onerror = () => { status.textContent = "Disconnected, retrying…"; };
+events.onmessage = (e) => {
+	const flights = JSON.parse(e.data);
+	tbody.replaceChildren(...flights.map((f) => {
+		const tr = document.createElement("tr");
+		if (f.holding) tr.className = "holding";
+		const cells = [
+			[f.handler], [f.holding ? "holding" : "waiting"], [f.remote], [f.path],
+			[f.waited_ms.toFixed(1), "num"], [f.holding ? f.held_ms.toFixed(1) : "", "num"],
+		];
+		for (const [text, cls] of cells) {
+			const td = document.createElement("td");
+			td.textContent = text;
+			if (cls) td.className = cls;
+			tr.appendChild(td);
+		}
+		return tr;
+	}));
+	if (flights.length === 0) status.textContent = "Live: no requests waiting for or holding a lock";
+	else status.textContent = "Live: " + flights.length + " in flight";
+};
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,78 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContentionEvents(t *testing.T) {
+	s, err := newServer("mutex", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.payloadBytes = testPayloadBytes
+	h, err := s.handler("bad")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+
+	conn := neverRead(t, ts.Listener.Addr().String())
+	waitFor(t, func() bool { return s.badCount.wait.count.Load() == 1 })
+	// A second request queues behind the slow client.
+	go func() {
+		client := &http.Client{Timeout: 5 * time.Second}
+		if resp, err := client.Get(ts.URL + "/bad?size=1"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	waitFor(t, func() bool { return len(s.flights.snapshot(time.Now())) == 2 })
+
+	resp, err := http.Get(ts.URL + "/debug/contention/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("want event stream, got %q", ct)
+	}
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var states []flightState
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &states); err != nil {
+		t.Fatalf("decoding %q: %v", line, err)
+	}
+	if len(states) != 2 {
+		t.Fatalf("want 2 requests in flight, got %+v", states)
+	}
+	holder, waiter := states[0], states[1]
+	if !holder.Holding || holder.Remote != conn.LocalAddr().String() {
+		t.Fatalf("want slow client %v holding the lock, got %+v", conn.LocalAddr(), holder)
+	}
+	if waiter.Holding || waiter.Path != "/bad?size=1" {
+		t.Fatalf("want second request waiting, got %+v", waiter)
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// A Counter counts requests. Implementations are safe for concurrent use, so
+// any locking in the handlers is about what else they do while counting.
+type Counter interface {
+	// Increment adds one to the count and returns the new count.
+	Increment(ctx context.Context) (int64, error)
+	// Close releases any resources held by the counter.
+	Close() error
+}
+
+// backends maps the name of each counter backend to a function opening the
+// counter with the given name, storing any state in dir.
+var backends = map[string]func(dir, name string) (Counter, error){
+	"mutex":  func(string, string) (Counter, error) { return &mutexCounter{}, nil },
+	"atomic": func(string, string) (Counter, error) { return &atomicCounter{}, nil },
+	"file":   openFileCounter,
+}
+
+// openCounter opens the named counter with the given backend.
+func openCounter(backend, dir, name string) (Counter, error) {
+	open, ok := backends[backend]
+	if !ok {
+		if backend == "sqlite" {
+			return nil, errors.New("the sqlite counter requires building with -tags sqlite")
+		}
+		names := make([]string, 0, len(backends))
+		for n := range backends {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown counter %q, want one of %s", backend, strings.Join(names, ", "))
+	}
+	return open(dir, name)
+}
+
+// mutexCounter is an in-memory count guarded by a mutex.
+type mutexCounter struct {
+	mu sync.Mutex
+	n  int64
+}
+
+func (c *mutexCounter) Increment(context.Context) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.n++
+	return c.n, nil
+}
+
+func (c *mutexCounter) Close() error { return nil }
+
+// atomicCounter is an in-memory count updated atomically.
+type atomicCounter struct {
+	n atomic.Int64
+}
+
+func (c *atomicCounter) Increment(context.Context) (int64, error) {
+	return c.n.Add(1), nil
+}
+
+func (c *atomicCounter) Close() error { return nil }
+
+// fileCounter persists its count to an append-only log holding one line per
+// increment, each the new count in decimal. Every increment is synced to disk
+// before it returns, so the count survives a crash, at the cost of holding the
+// counter's locks across an fsync.
+//
+// The log may be shared by several servers on the same host. Each increment
+// takes an exclusive flock on the log and reads the last count from it, so no
+// count is written twice. Whether flock is honored on network file systems
+// varies, so only SQLite should be relied on to share a count more widely.
+type fileCounter struct {
+	// mu serializes increments within the process, since flock does not
+	// exclude other goroutines using the same file.
+	mu sync.Mutex
+	f  *os.File
+}
+
+// openFileCounter opens the log name.log in dir, creating it if needed, and
+// checks that its last record can be read.
+func openFileCounter(dir, name string) (Counter, error) {
+	f, err := os.OpenFile(filepath.Join(dir, name+".log"), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	c := &fileCounter{f: f}
+	if err := c.locked(func(int64) error { return nil }); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("recovering %s: %w", f.Name(), err)
+	}
+	return c, nil
+}
+
+// maxRecordBytes is the length of the longest record: the digits of the
+// largest int64 and a newline.
+const maxRecordBytes = 20
+
+// locked calls fn with the last count in the log while holding the log's
+// exclusive flock.
+func (c *fileCounter) locked(fn func(n int64) error) error {
+	if err := lockFile(c.f); err != nil {
+		return err
+	}
+	defer unlockFile(c.f)
+	n, err := lastCount(c.f)
+	if err != nil {
+		return err
+	}
+	return fn(n)
+}
+
+// lastCount returns the last count recorded in f. A final line without a
+// newline is the remains of an interrupted write and is truncated away, which
+// is safe only while holding the log's lock.
+func lastCount(f *os.File) (int64, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	// The tail holds the last record, any torn record after it, and the
+	// newline before it.
+	size := fi.Size()
+	tail := make([]byte, min(size, 3*maxRecordBytes))
+	off := size - int64(len(tail))
+	if _, err := f.ReadAt(tail, off); err != nil {
+		return 0, err
+	}
+	if end := bytes.LastIndexByte(tail, '\n') + 1; end < len(tail) {
+		if end == 0 && off > 0 {
+			return 0, fmt.Errorf("corrupt record at offset %d", off)
+		}
+		if err := f.Truncate(off + int64(end)); err != nil {
+			return 0, err
+		}
+		tail = tail[:end]
+	}
+	if len(tail) == 0 {
+		return 0, nil
+	}
+	last := tail[bytes.LastIndexByte(tail[:len(tail)-1], '\n')+1 : len(tail)-1]
+	n, err := strconv.ParseInt(string(last), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("corrupt record %q: %w", last, err)
+	}
+	return n, nil
+}
+
+func (c *fileCounter) Increment(context.Context) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var next int64
+	err := c.locked(func(n int64) error {
+		next = n + 1
+		if _, err := c.f.WriteString(strconv.FormatInt(next, 10) + "\n"); err != nil {
+			return err
+		}
+		return c.f.Sync()
+	})
+	if err != nil {
+		return 0, err
+	}
+	return next, nil
+}
+
+func (c *fileCounter) Close() error {
+	return c.f.Close()
+}
@@ -0,0 +1,68 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build sqlite
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	backends["sqlite"] = openSQLiteCounter
+}
+
+// sqliteCounter stores its count in a single-row table of a SQLite database.
+// SQLite serializes writers itself, so increments contend inside the database
+// rather than on a Go mutex.
+type sqliteCounter struct {
+	db *sql.DB
+}
+
+// openSQLiteCounter opens the database name.db in dir, creating it if needed.
+func openSQLiteCounter(dir, name string) (Counter, error) {
+	db, err := sql.Open("sqlite3", filepath.Join(dir, name+".db"))
+	if err != nil {
+		return nil, err
+	}
+	// One connection avoids SQLITE_BUSY errors between writers in this
+	// process.
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS counter (
+			id INTEGER PRIMARY KEY CHECK (id = 0),
+			count INTEGER NOT NULL
+		);
+		INSERT OR IGNORE INTO counter (id, count) VALUES (0, 0);`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteCounter{db: db}, nil
+}
+
+func (c *sqliteCounter) Increment(ctx context.Context) (int64, error) {
+	var n int64
+	err := c.db.QueryRowContext(ctx, "UPDATE counter SET count = count + 1 WHERE id = 0 RETURNING count").Scan(&n)
+	return n, err
+}
+
+func (c *sqliteCounter) Close() error {
+	return c.db.Close()
+}
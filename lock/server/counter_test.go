@@ -0,0 +1,147 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestCounters(t *testing.T) {
+	ctx := context.Background()
+	for backend := range backends {
+		t.Run(backend, func(t *testing.T) {
+			dir := t.TempDir()
+			c, err := openCounter(backend, dir, "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 50; j++ {
+						if _, err := c.Increment(ctx); err != nil {
+							t.Error(err)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			if got, err := c.Increment(ctx); err != nil || got != 401 {
+				t.Fatalf("want 401, got %d, %v", got, err)
+			}
+			if err := c.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Persistent counters pick up where they left off.
+			want := int64(1)
+			if backend == "file" || backend == "sqlite" {
+				want = 402
+			}
+			c, err = openCounter(backend, dir, "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			if got, err := c.Increment(ctx); err != nil || got != want {
+				t.Fatalf("want %d after reopening, got %d, %v", want, got, err)
+			}
+		})
+	}
+}
+
+func TestFileCounterTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.log")
+	if err := os.WriteFile(path, []byte("1\n2\n3"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := openFileCounter(dir, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if got, err := c.Increment(context.Background()); err != nil || got != 3 {
+		t.Fatalf("want 3, got %d, %v", got, err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "1\n2\n3\n"; string(b) != want {
+		t.Fatalf("want log %q, got %q", want, b)
+	}
+}
+
+func TestFileCounterShared(t *testing.T) {
+	// Two counters on one log stand in for two servers sharing -data.
+	dir := t.TempDir()
+	var counters [2]Counter
+	for i := range counters {
+		c, err := openFileCounter(dir, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		counters[i] = c
+	}
+
+	const each = 100
+	seen := make(chan int64, 2*each)
+	var wg sync.WaitGroup
+	for _, c := range counters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				n, err := c.Increment(context.Background())
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				seen <- n
+			}
+		}()
+	}
+	wg.Wait()
+	close(seen)
+
+	counts := make(map[int64]bool)
+	for n := range seen {
+		if counts[n] {
+			t.Fatalf("count %d returned twice", n)
+		}
+		counts[n] = true
+	}
+	for n := int64(1); n <= 2*each; n++ {
+		if !counts[n] {
+			t.Fatalf("count %d never returned", n)
+		}
+	}
+}
+
+func TestUnknownCounter(t *testing.T) {
+	if _, err := openCounter("carrier-pigeon", t.TempDir(), "test"); err == nil {
+		t.Fatal("want error for unknown counter")
+	}
+}
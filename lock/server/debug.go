@@ -0,0 +1,98 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// bounds are the upper bounds of the histogram buckets. Durations beyond the
+// last bound are counted in a final overflow bucket.
+var bounds = [...]time.Duration{
+	time.Microsecond,
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// A histogram counts durations in exponentially sized buckets. It is safe for
+// concurrent use.
+type histogram struct {
+	buckets [len(bounds) + 1]atomic.Uint64
+	count   atomic.Uint64
+	sum     atomic.Int64
+	max     atomic.Int64
+}
+
+// observe records d.
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(bounds) && d > bounds[i] {
+		i++
+	}
+	h.buckets[i].Add(1)
+	h.count.Add(1)
+	h.sum.Add(int64(d))
+	for {
+		m := h.max.Load()
+		if int64(d) <= m || h.max.CompareAndSwap(m, int64(d)) {
+			break
+		}
+	}
+}
+
+// write prints a summary of h followed by the count in each bucket.
+func (h *histogram) write(w *tabwriter.Writer, name string) {
+	n := h.count.Load()
+	var mean time.Duration
+	if n > 0 {
+		mean = time.Duration(h.sum.Load() / int64(n))
+	}
+	fmt.Fprintf(w, "  %s\tcount=%d\tmean=%v\tmax=%v\n", name, n, mean, time.Duration(h.max.Load()))
+	for i := range h.buckets {
+		label := "+Inf"
+		if i < len(bounds) {
+			label = "≤" + bounds[i].String()
+		}
+		fmt.Fprintf(w, "    %s\t%d\n", label, h.buckets[i].Load())
+	}
+}
+
+// debug reports how long each handler's requests waited for and held its lock.
+func (s *server) debug(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, c := range []struct {
+		name    string
+		counter *lockedCounter
+	}{
+		{"bad", &s.badCount},
+		{"good", &s.goodCount},
+	} {
+		fmt.Fprintf(tw, "%s\t%s counter\n", c.name, s.backend)
+		c.counter.wait.write(tw, "lock wait")
+		c.counter.hold.write(tw, "lock hold")
+	}
+	fmt.Fprintf(tw, "atomic\n  no lock\tcount=%d\n", s.atomicCount.Load())
+	tw.Flush()
+}
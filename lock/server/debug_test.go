@@ -0,0 +1,126 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistogramObserve(t *testing.T) {
+	overflow := len(bounds)
+	tests := []struct {
+		d      time.Duration
+		bucket int
+	}{
+		{0, 0},
+		{time.Microsecond, 0},
+		{time.Microsecond + 1, 1},
+		{10 * time.Microsecond, 1},
+		{time.Millisecond, 3},
+		{time.Second, 6},
+		{10 * time.Second, 7},
+		{10*time.Second + 1, overflow},
+		{time.Hour, overflow},
+	}
+	for _, tc := range tests {
+		var h histogram
+		h.observe(tc.d)
+		for i := range h.buckets {
+			want := uint64(0)
+			if i == tc.bucket {
+				want = 1
+			}
+			if got := h.buckets[i].Load(); got != want {
+				t.Errorf("observe(%v): want %d in bucket %d, got %d", tc.d, want, i, got)
+			}
+		}
+	}
+
+	var h histogram
+	h.observe(time.Millisecond)
+	h.observe(3 * time.Millisecond)
+	if n, sum, max := h.count.Load(), time.Duration(h.sum.Load()), time.Duration(h.max.Load()); n != 2 || sum != 4*time.Millisecond || max != 3*time.Millisecond {
+		t.Fatalf("want count=2 sum=4ms max=3ms, got count=%d sum=%v max=%v", n, sum, max)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	s, err := newServer("mutex", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := s.handler("nope"); err == nil {
+		t.Fatal("want an error for an unknown handler")
+	}
+	h, err := s.handler("good")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	get := func(path string) string {
+		t.Helper()
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: want status %d, got %d", path, http.StatusOK, resp.StatusCode)
+		}
+		return string(b)
+	}
+	// / is served by the good handler, so the good count reaches 2.
+	for _, tc := range []struct{ path, want string }{
+		{"/bad?size=1", "1"},
+		{"/good?size=1", "1"},
+		{"/?size=1", "2"},
+		{"/atomic?size=1", "1"},
+	} {
+		if got := get(tc.path); got != tc.want {
+			t.Errorf("%s: want %q, got %q", tc.path, tc.want, got)
+		}
+	}
+
+	// Compare the report with its columns collapsed to single spaces.
+	debug := strings.Join(strings.Fields(get("/debug")), " ")
+	bad, rest, ok1 := strings.Cut(debug, "good mutex counter")
+	good, atomic, ok2 := strings.Cut(rest, "atomic no lock")
+	if !ok1 || !ok2 || !strings.HasPrefix(bad, "bad mutex counter") {
+		t.Fatalf("want bad, good and atomic sections in /debug, got %q", debug)
+	}
+	for _, tc := range []struct{ section, want string }{
+		{bad, "lock wait count=1 "},
+		{bad, "lock hold count=1 "},
+		{good, "lock wait count=2 "},
+		{good, "lock hold count=2 "},
+		{atomic, "count=1"},
+	} {
+		if !strings.Contains(tc.section, tc.want) {
+			t.Errorf("want %q in /debug section %q", tc.want, tc.section)
+		}
+	}
+}
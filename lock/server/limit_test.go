@@ -0,0 +1,90 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLimitListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := limitListener(inner, 1)
+	defer l.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- c
+		}
+	}()
+	dial := func() net.Conn {
+		t.Helper()
+		c, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	accept := func() net.Conn {
+		t.Helper()
+		select {
+		case c := <-accepted:
+			return c
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for Accept")
+			return nil
+		}
+	}
+
+	dial()
+	first := accept()
+
+	// The second connection is over the limit, so it is answered and closed
+	// without being returned by Accept.
+	over := dial()
+	over.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b, err := io.ReadAll(over)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != overloaded {
+		t.Fatalf("want %q, got %q", overloaded, b)
+	}
+
+	// Closing the first connection frees its slot, even if closed twice.
+	first.Close()
+	first.Close()
+	dial()
+	second := accept()
+	defer second.Close()
+	dial()
+	select {
+	case c := <-accepted:
+		t.Fatalf("want one connection at a time, got another from %v", c.RemoteAddr())
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -12,69 +12,241 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// The server provides two handlers, one subject to performance problems, the
-// other not.
+// The server provides three handlers, one subject to performance problems, the
+// others not. Each handler keeps its own counter so they can be compared side
+// by side, and the time spent waiting for and holding each lock is reported at
+// /debug.
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
-	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
-	// payloadBytes is the number of bytes written back to the client. The value
-	// ensures all writes to a client socket fill the TCP buffer. The TCP buffer
-	// size is controlled by kernel configuration. To learn more about the TCP
-	// configuration, see: http://fasterdata.es.net/host-tuning/
+	// payloadBytes is the default number of bytes written back to the
+	// client. The value ensures all writes to a client socket fill the TCP
+	// buffer. The TCP buffer size is controlled by kernel configuration. To
+	// learn more about the TCP configuration, see:
+	// http://fasterdata.es.net/host-tuning/
 	payloadBytes = 1024 * 1024
-)
 
-var (
-	// For synchronization of counters, the atomic package offers many helpful
-	// functions. Typically, something like atomic.AddInt64 might be a better
-	// choice for synchronized counters, but for the sake of demonstrating some
-	// pifalls of locking, a mutex is used for synchronization instead.
-	// See: https://golang.org/pkg/sync/atomic/
-	mu    sync.Mutex
-	count int
+	// maxPayloadBytes is the default largest response a client may ask for
+	// with the size query parameter. Without a limit, a client could hold
+	// the bad handler's lock indefinitely even while reading at full speed.
+	maxPayloadBytes = 256 * 1024 * 1024
 )
 
 func main() {
-	http.HandleFunc("/", root)
+	var (
+		addr          = flag.String("addr", ":8080", "address to listen on")
+		root          = flag.String("handler", "bad", "handler serving /: bad, good or atomic")
+		readTimeout   = flag.Duration("read-timeout", 10*time.Second, "maximum duration for reading a request, 0 for none")
+		writeTimeout  = flag.Duration("write-timeout", 0, "maximum duration from reading a request's headers to writing its response, 0 for none")
+		idleTimeout   = flag.Duration("idle-timeout", time.Minute, "how long to keep an idle keep-alive connection open, 0 for the read timeout")
+		writeDeadline = flag.Duration("write-deadline", 0, "maximum duration for a handler to write its response, 0 for none")
+		maxConns      = flag.Int("max-conns", 0, "maximum number of concurrent connections, 0 for no limit")
+		maxSize       = flag.Int64("max-size", maxPayloadBytes, "largest response size a request may ask for, in bytes")
+		backend       = flag.String("counter", "mutex", "counter behind the bad and good handlers: mutex, atomic, file or sqlite")
+		dir           = flag.String("data", ".", "directory holding the file and sqlite counters")
+	)
+	flag.Parse()
 
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	s, err := newServer(*backend, *dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer s.Close()
+	s.writeDeadline = *writeDeadline
+	s.maxPayloadBytes = *maxSize
+	h, err := s.handler(*root)
+	if err != nil {
 		log.Fatal(err)
 	}
+	srv := &http.Server{
+		Handler:      h,
+		ReadTimeout:  *readTimeout,
+		WriteTimeout: *writeTimeout,
+		IdleTimeout:  *idleTimeout,
+	}
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *maxConns > 0 {
+		l = limitListener(l, *maxConns)
+	}
+	if err := srv.Serve(l); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// server holds the counters behind each handler.
+type server struct {
+	// writeDeadline bounds how long a handler may spend writing its
+	// response. A client that reads too slowly is disconnected and the
+	// handler's write fails, releasing any lock held around it.
+	writeDeadline time.Duration
+	// payloadBytes is the size of a response when the request does not
+	// specify one.
+	payloadBytes int
+	// maxPayloadBytes is the largest size a request may ask for.
+	maxPayloadBytes int64
+
+	// backend names the kind of Counter behind badCount and goodCount.
+	backend   string
+	badCount  lockedCounter
+	goodCount lockedCounter
+	// flights tracks the requests waiting for or holding the locks of
+	// badCount and goodCount.
+	flights flights
+
+	// For synchronization of counters, the atomic package offers many
+	// helpful functions and is usually the better choice. The bad and good
+	// handlers use a mutex instead for the sake of demonstrating some pitfalls
+	// of locking. See: https://golang.org/pkg/sync/atomic/
+	atomicCount atomic.Int64
+}
+
+// newServer returns a server whose bad and good handlers count requests with
+// the named counter backend, storing any state in dir.
+func newServer(backend, dir string) (*server, error) {
+	s := &server{
+		payloadBytes:    payloadBytes,
+		maxPayloadBytes: maxPayloadBytes,
+		backend:         backend,
+	}
+	s.badCount.name, s.badCount.flights = "bad", &s.flights
+	s.goodCount.name, s.goodCount.flights = "good", &s.flights
+	var err error
+	if s.badCount.counter, err = openCounter(backend, dir, "bad"); err != nil {
+		return nil, err
+	}
+	if s.goodCount.counter, err = openCounter(backend, dir, "good"); err != nil {
+		s.badCount.counter.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close closes the counters of s.
+func (s *server) Close() error {
+	return errors.Join(s.badCount.counter.Close(), s.goodCount.counter.Close())
+}
+
+// handler returns the routes of s, with / served by the handler named root.
+func (s *server) handler(root string) (http.Handler, error) {
+	handlers := map[string]http.HandlerFunc{
+		"bad":    s.bad,
+		"good":   s.good,
+		"atomic": s.atomic,
+	}
+	h, ok := handlers[root]
+	if !ok {
+		return nil, fmt.Errorf("unknown handler %q", root)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", h)
+	for name, h := range handlers {
+		mux.HandleFunc("/"+name, h)
+	}
+	mux.HandleFunc("/debug", s.debug)
+	mux.HandleFunc("/debug/contention", s.contention)
+	mux.HandleFunc("/debug/contention/events", s.contentionEvents)
+	return s.withWriteDeadline(mux), nil
 }
 
-// BAD: This version of root holds the lock around the write to the client,
-// which means if the client is slow to read, then the handler cannot quickly
-// release the lock and respond to the next request.
-func root(w http.ResponseWriter, r *http.Request) {
-	mu.Lock()
-	defer mu.Unlock()
+// withWriteDeadline sets the write deadline of every response served by h.
+func (s *server) withWriteDeadline(h http.Handler) http.Handler {
+	if s.writeDeadline <= 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		if err := rc.SetWriteDeadline(time.Now().Add(s.writeDeadline)); err != nil {
+			log.Printf("setting write deadline: %v", err)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
 
-	count++
+// BAD: This handler holds the lock around the write to the client, which
+// means if the client is slow to read, then the handler cannot quickly release
+// the lock and respond to the next request.
+func (s *server) bad(w http.ResponseWriter, r *http.Request) {
+	unlock := s.badCount.lock(r)
+	defer unlock()
 
-	msg := []byte(strings.Repeat(fmt.Sprintf("%d", count), payloadBytes))
-	w.Write(msg)
+	count, err := s.badCount.counter.Increment(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.serve(w, r, count)
 }
 
-// GOOD: This version of root holds the lock as shortly as possible,
-// incrementing count and storing a copy of the current count value, before
-// releasing the lock. If a client is slow to read, the handler may still be
-// invoked a second time without creating any lock contention.
-/*
-func root(w http.ResponseWriter, r *http.Request) {
-	mu.Lock()
-	count++
-	current := count
-	mu.Unlock()
-
-	msg := []byte(strings.Repeat(fmt.Sprintf("%d", current), payloadBytes))
-	w.Write([]byte(msg))
+// GOOD: This handler holds the lock as shortly as possible, incrementing count
+// and storing a copy of the current count value, before releasing the lock. If
+// a client is slow to read, the handler may still be invoked a second time
+// without creating any lock contention.
+func (s *server) good(w http.ResponseWriter, r *http.Request) {
+	unlock := s.goodCount.lock(r)
+	current, err := s.goodCount.counter.Increment(r.Context())
+	unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.serve(w, r, current)
+}
+
+// atomic avoids the lock altogether by incrementing the count atomically.
+func (s *server) atomic(w http.ResponseWriter, r *http.Request) {
+	current := s.atomicCount.Add(1)
+
+	s.serve(w, r, current)
+}
+
+// A lockedCounter is a Counter guarded by a mutex. It records how long callers
+// wait to acquire the mutex and how long they hold it, and tracks the requests
+// doing so in flights.
+type lockedCounter struct {
+	mu      sync.Mutex
+	counter Counter
+	name    string
+	flights *flights
+
+	wait, hold histogram
+}
+
+// lock acquires the mutex on behalf of r and returns a function that releases
+// it.
+func (c *lockedCounter) lock(r *http.Request) (unlock func()) {
+	start := time.Now()
+	id := c.flights.add(&flight{
+		handler: c.name,
+		remote:  r.RemoteAddr,
+		path:    r.URL.RequestURI(),
+		start:   start,
+	})
+	c.mu.Lock()
+	acquired := time.Now()
+	c.flights.acquire(id, acquired)
+	c.wait.observe(acquired.Sub(start))
+	return func() {
+		c.hold.observe(time.Since(acquired))
+		c.mu.Unlock()
+		c.flights.remove(id)
+	}
 }
-*/
@@ -0,0 +1,240 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPayloadBytes is large enough to fill the kernel's socket buffers on
+// both ends of a loopback connection, so that writing it to a client that
+// never reads blocks.
+const testPayloadBytes = 32 * 1024 * 1024
+
+func TestSlowClient(t *testing.T) {
+	prev := runtime.SetMutexProfileFraction(1)
+	defer runtime.SetMutexProfileFraction(prev)
+
+	tests := []struct {
+		handler string
+		blocked bool
+	}{
+		{handler: "good", blocked: false},
+		{handler: "bad", blocked: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.handler, func(t *testing.T) {
+			s, err := newServer("mutex", t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			s.payloadBytes = testPayloadBytes
+			h, err := s.handler(tc.handler)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ts := httptest.NewServer(h)
+			// Close runs after the slow client disconnects, since
+			// cleanups run in reverse order.
+			t.Cleanup(ts.Close)
+
+			counter := &s.goodCount
+			if tc.handler == "bad" {
+				counter = &s.badCount
+			}
+
+			conn := neverRead(t, ts.Listener.Addr().String())
+			// Wait for the slow client's request to take the lock.
+			waitFor(t, func() bool { return counter.wait.count.Load() == 1 })
+
+			client := &http.Client{Timeout: time.Second}
+			resp, err := client.Get(ts.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if blocked := err != nil; blocked != tc.blocked {
+				t.Fatalf("want blocked=%v, got error %v", tc.blocked, err)
+			}
+			if !tc.blocked {
+				return
+			}
+
+			// Disconnecting the slow client releases the lock to the
+			// second request, recording the contention in the mutex
+			// profile.
+			conn.Close()
+			waitFor(t, func() bool { return counter.wait.count.Load() == 2 })
+			var buf bytes.Buffer
+			if err := pprof.Lookup("mutex").WriteTo(&buf, 1); err != nil {
+				t.Fatal(err)
+			}
+			t.Logf("mutex profile:\n%s", &buf)
+			if !strings.Contains(buf.String(), "lockedCounter") {
+				t.Fatal("want contention on lockedCounter in mutex profile")
+			}
+		})
+	}
+}
+
+// TestWriteDeadline checks that a write deadline frees the bad handler's lock
+// from a client that never reads, so later requests are served.
+func TestWriteDeadline(t *testing.T) {
+	s, err := newServer("mutex", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.payloadBytes = testPayloadBytes
+	s.writeDeadline = 100 * time.Millisecond
+	h, err := s.handler("bad")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+
+	neverRead(t, ts.Listener.Addr().String())
+	waitFor(t, func() bool { return s.badCount.wait.count.Load() == 1 })
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(ts.URL + "?size=1")
+	if err != nil {
+		t.Fatalf("want the deadline to release the lock, got %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("want status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+}
+
+// neverRead sends a GET request to addr and never reads the response.
+func neverRead(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := conn.(*net.TCPConn).SetReadBuffer(4096); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\n\r\n", addr); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// waitFor polls cond until it is true, failing the test after a few seconds.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPayload(t *testing.T) {
+	p := newPayload(123, 7)
+	b, err := io.ReadAll(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "1231231"; string(b) != want {
+		t.Fatalf("want %q, got %q", want, b)
+	}
+
+	if _, err := p.Seek(4, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	b, err = io.ReadAll(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "231"; string(b) != want {
+		t.Fatalf("want %q, got %q", want, b)
+	}
+}
+
+func TestServePayload(t *testing.T) {
+	s, err := newServer("mutex", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	h, err := s.handler("atomic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	tests := []struct {
+		name   string
+		query  string
+		rng    string
+		status int
+		body   string
+	}{
+		{name: "size", query: "?size=5", status: http.StatusOK, body: "11111"},
+		{name: "empty", query: "?size=0", status: http.StatusOK, body: ""},
+		{name: "range", query: "?size=100", rng: "bytes=10-12", status: http.StatusPartialContent, body: "333"},
+		{name: "unsatisfiable range", query: "?size=10", rng: "bytes=20-", status: http.StatusRequestedRangeNotSatisfiable},
+		{name: "invalid size", query: "?size=-1", status: http.StatusBadRequest},
+		{name: "too large", query: fmt.Sprintf("?size=%d", maxPayloadBytes+1), status: http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+tc.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.rng != "" {
+				req.Header.Set("Range", tc.rng)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tc.status {
+				t.Fatalf("want status %d, got %d", tc.status, resp.StatusCode)
+			}
+			if tc.status >= 400 {
+				return
+			}
+			b, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tc.body {
+				t.Fatalf("want %q, got %q", tc.body, b)
+			}
+		})
+	}
+}
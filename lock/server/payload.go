@@ -0,0 +1,117 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// chunkBytes is the size of each chunk of a response written before flushing.
+const chunkBytes = 32 * 1024
+
+// A payload is a response body of size bytes made of the digits of a count,
+// repeated. It is generated as it is read, so serving one takes the same
+// memory however large it is.
+type payload struct {
+	digits string
+	size   int64
+	off    int64
+}
+
+func newPayload(count int64, size int64) *payload {
+	return &payload{digits: strconv.FormatInt(count, 10), size: size}
+}
+
+func (p *payload) Read(b []byte) (int, error) {
+	if p.off >= p.size {
+		return 0, io.EOF
+	}
+	if rem := p.size - p.off; int64(len(b)) > rem {
+		b = b[:rem]
+	}
+	n := 0
+	for n < len(b) {
+		m := copy(b[n:], p.digits[p.off%int64(len(p.digits)):])
+		n += m
+		p.off += int64(m)
+	}
+	return n, nil
+}
+
+func (p *payload) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += p.off
+	case io.SeekEnd:
+		offset += p.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	p.off = offset
+	return offset, nil
+}
+
+// serve streams the payload for count to w. The size query parameter sets the
+// size of the payload, up to s.maxPayloadBytes, and Range requests are
+// honored.
+func (s *server) serve(w http.ResponseWriter, r *http.Request, count int64) {
+	size := int64(s.payloadBytes)
+	if v := r.URL.Query().Get("size"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid size", http.StatusBadRequest)
+			return
+		}
+		if n > s.maxPayloadBytes {
+			http.Error(w, fmt.Sprintf("size exceeds the maximum of %d bytes", s.maxPayloadBytes), http.StatusBadRequest)
+			return
+		}
+		size = n
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fw := &flushWriter{ResponseWriter: w, rc: http.NewResponseController(w)}
+	http.ServeContent(fw, r, "", time.Time{}, newPayload(count, size))
+}
+
+// flushWriter writes to the client in chunks of chunkBytes, flushing each one
+// so that the response is sent as it is produced rather than buffered.
+type flushWriter struct {
+	http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func (w *flushWriter) Write(b []byte) (int, error) {
+	n := 0
+	for n < len(b) {
+		m, err := w.ResponseWriter.Write(b[n:min(n+chunkBytes, len(b))])
+		n += m
+		if err != nil {
+			return n, err
+		}
+		if err := w.rc.Flush(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
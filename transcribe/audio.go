@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// errUnknownFormat is returned by detectFormat for audio that is neither FLAC
+// nor WAV, such as raw PCM, whose format cannot be read from the data.
+var errUnknownFormat = errors.New("unknown audio format")
+
+// An audioFormat describes encoded audio. Encoding uses the lower-case names
+// accepted by the -encoding flag.
+type audioFormat struct {
+	Encoding   string
+	SampleRate int
+	Channels   int
+}
+
+func (f audioFormat) String() string {
+	return fmt.Sprintf("%s at %d Hz with %d channel(s)", f.Encoding, f.SampleRate, f.Channels)
+}
+
+// detectFormat reads the format of FLAC and WAV audio from its header.
+func detectFormat(data []byte) (audioFormat, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("fLaC")):
+		return flacFormat(data)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WAVE":
+		return wavFormat(data)
+	}
+	return audioFormat{}, errUnknownFormat
+}
+
+// flacFormat reads the STREAMINFO metadata block, which the FLAC format
+// requires to come first, immediately after the "fLaC" marker.
+func flacFormat(data []byte) (audioFormat, error) {
+	const streamInfoLen = 34
+	if len(data) < 8+streamInfoLen {
+		return audioFormat{}, errors.New("truncated FLAC header")
+	}
+	// Each metadata block starts with a byte holding a last-block flag and
+	// the block type, followed by a 24-bit length.
+	if typ := data[4] & 0x7f; typ != 0 {
+		return audioFormat{}, fmt.Errorf("FLAC stream starts with metadata block type %d, want STREAMINFO", typ)
+	}
+	info := data[8 : 8+streamInfoLen]
+	// After the block and frame sizes come a 20-bit sample rate and the
+	// number of channels less one in 3 bits.
+	rate := int(info[10])<<12 | int(info[11])<<4 | int(info[12])>>4
+	channels := int(info[12]>>1&0x7) + 1
+	if rate == 0 {
+		return audioFormat{}, errors.New("FLAC STREAMINFO has a sample rate of zero")
+	}
+	return audioFormat{Encoding: "flac", SampleRate: rate, Channels: channels}, nil
+}
+
+// WAV format tags, as found in the fmt chunk.
+const (
+	wavPCM        = 1
+	wavMULAW      = 7
+	wavExtensible = 0xfffe
+)
+
+// wavFormat reads the fmt chunk of a RIFF WAVE file.
+func wavFormat(data []byte) (audioFormat, error) {
+	le := binary.LittleEndian
+	for chunks := data[12:]; len(chunks) >= 8; {
+		id, size := string(chunks[:4]), int(le.Uint32(chunks[4:8]))
+		body := chunks[8:]
+		if size > len(body) {
+			return audioFormat{}, fmt.Errorf("truncated WAV %q chunk", id)
+		}
+		if id != "fmt " {
+			// Chunks are padded to an even number of bytes.
+			chunks = body[min(size+size%2, len(body)):]
+			continue
+		}
+		if size < 16 {
+			return audioFormat{}, fmt.Errorf("WAV fmt chunk is %d bytes, want at least 16", size)
+		}
+		tag := le.Uint16(body[0:2])
+		channels := int(le.Uint16(body[2:4]))
+		rate := int(le.Uint32(body[4:8]))
+		bits := le.Uint16(body[14:16])
+		if tag == wavExtensible && size >= 26 {
+			// The actual format is the start of the subformat GUID.
+			tag = le.Uint16(body[24:26])
+		}
+
+		f := audioFormat{SampleRate: rate, Channels: channels}
+		switch {
+		case tag == wavPCM && bits == 16:
+			f.Encoding = "linear16"
+		case tag == wavMULAW && bits == 8:
+			f.Encoding = "mulaw"
+		case tag == wavPCM:
+			return audioFormat{}, fmt.Errorf("WAV has %d-bit samples, but only 16-bit PCM is supported", bits)
+		default:
+			return audioFormat{}, fmt.Errorf("WAV has unsupported format tag %#x", tag)
+		}
+		return f, nil
+	}
+	return audioFormat{}, errors.New("WAV has no fmt chunk")
+}
+
+// resolveFormat combines the requested format with the one detected in data.
+// Zero fields of want are filled in from the detected format, and any other
+// field must match it. Audio whose format cannot be detected must be fully
+// described by want.
+func resolveFormat(want audioFormat, data []byte) (audioFormat, error) {
+	got, err := detectFormat(data)
+	if errors.Is(err, errUnknownFormat) {
+		if want.Encoding == "" || want.SampleRate == 0 {
+			return audioFormat{}, errors.New("cannot detect the audio format; set -encoding and -rate")
+		}
+		return want, nil
+	}
+	if err != nil {
+		return audioFormat{}, err
+	}
+
+	if (want.Encoding != "" && want.Encoding != got.Encoding) ||
+		(want.SampleRate != 0 && want.SampleRate != got.SampleRate) ||
+		(want.Channels != 0 && want.Channels != got.Channels) {
+		return audioFormat{}, fmt.Errorf("audio is %v, which does not match the requested %v", got, describe(want))
+	}
+	return got, nil
+}
+
+// describe formats a requested format, which may be partly unset.
+func describe(f audioFormat) string {
+	var parts []string
+	if f.Encoding != "" {
+		parts = append(parts, "-encoding "+f.Encoding)
+	}
+	if f.SampleRate != 0 {
+		parts = append(parts, fmt.Sprintf("-rate %d", f.SampleRate))
+	}
+	if f.Channels != 0 {
+		parts = append(parts, fmt.Sprintf("-channels %d", f.Channels))
+	}
+	return strings.Join(parts, " ")
+}
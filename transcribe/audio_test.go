@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// flacHeader returns the start of a FLAC stream with the given format.
+func flacHeader(rate, channels, bits int) []byte {
+	b := append([]byte("fLaC"), 0x80, 0, 0, 34)
+	info := make([]byte, 34)
+	info[10] = byte(rate >> 12)
+	info[11] = byte(rate >> 4)
+	info[12] = byte(rate&0xf)<<4 | byte(channels-1)<<1 | byte(bits-1)>>4
+	info[13] = byte(bits-1) << 4
+	return append(b, info...)
+}
+
+// wavHeader returns the start of a WAV file with the given format. An odd-sized
+// chunk precedes the fmt chunk to exercise padding.
+func wavHeader(tag uint16, rate, channels int, bits uint16) []byte {
+	le := binary.LittleEndian
+	b := []byte("RIFF\x00\x00\x00\x00WAVE")
+	b = append(b, "LIST\x03\x00\x00\x00abc\x00"...)
+	b = append(b, "fmt \x10\x00\x00\x00"...)
+	b = le.AppendUint16(b, tag)
+	b = le.AppendUint16(b, uint16(channels))
+	b = le.AppendUint32(b, uint32(rate))
+	b = le.AppendUint32(b, uint32(rate*channels*int(bits)/8))
+	b = le.AppendUint16(b, uint16(channels*int(bits)/8))
+	b = le.AppendUint16(b, bits)
+	return b
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want audioFormat
+		err  string
+	}{
+		{
+			name: "flac",
+			data: flacHeader(44100, 2, 16),
+			want: audioFormat{Encoding: "flac", SampleRate: 44100, Channels: 2},
+		},
+		{
+			name: "flac 96k mono",
+			data: flacHeader(96000, 1, 24),
+			want: audioFormat{Encoding: "flac", SampleRate: 96000, Channels: 1},
+		},
+		{
+			name: "truncated flac",
+			data: flacHeader(16000, 1, 16)[:20],
+			err:  "truncated",
+		},
+		{
+			name: "wav pcm",
+			data: wavHeader(wavPCM, 16000, 1, 16),
+			want: audioFormat{Encoding: "linear16", SampleRate: 16000, Channels: 1},
+		},
+		{
+			name: "wav mulaw",
+			data: wavHeader(wavMULAW, 8000, 1, 8),
+			want: audioFormat{Encoding: "mulaw", SampleRate: 8000, Channels: 1},
+		},
+		{
+			name: "wav 8-bit pcm",
+			data: wavHeader(wavPCM, 8000, 1, 8),
+			err:  "only 16-bit PCM",
+		},
+		{
+			name: "wav float",
+			data: wavHeader(3, 48000, 2, 32),
+			err:  "unsupported format tag",
+		},
+		{
+			name: "raw",
+			data: make([]byte, 64),
+			err:  errUnknownFormat.Error(),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := detectFormat(tc.data)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("want error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Fatalf("want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestResolveFormat(t *testing.T) {
+	flac := flacHeader(44100, 2, 16)
+
+	got, err := resolveFormat(audioFormat{}, flac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (audioFormat{Encoding: "flac", SampleRate: 44100, Channels: 2}); got != want {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+
+	_, err = resolveFormat(audioFormat{Encoding: "flac", SampleRate: 16000}, flac)
+	if err == nil || !strings.Contains(err.Error(), "-rate 16000") {
+		t.Fatalf("want sample rate mismatch, got %v", err)
+	}
+
+	_, err = resolveFormat(audioFormat{Encoding: "linear16"}, flac)
+	if err == nil || !strings.Contains(err.Error(), "-encoding linear16") {
+		t.Fatalf("want encoding mismatch, got %v", err)
+	}
+
+	raw := make([]byte, 64)
+	if _, err := resolveFormat(audioFormat{Encoding: "linear16"}, raw); err == nil {
+		t.Fatal("want error for raw audio without a sample rate")
+	}
+	want := audioFormat{Encoding: "linear16", SampleRate: 8000}
+	got, err = resolveFormat(want, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	if _, err := detectFormat(raw); !errors.Is(err, errUnknownFormat) {
+		t.Fatalf("want errUnknownFormat, got %v", err)
+	}
+}
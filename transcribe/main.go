@@ -1,18 +1,84 @@
-// The transcribe binary submits an audio sample to Google Cloud Platform's
+// The transcribe binary submits audio samples to Google Cloud Platform's
 // Speech-to-Text API for transcription.
+//
+// Usage:
+//
+//	transcribe [flags] path...
+//
+// Each path is an audio file or a directory, in which case every audio file
+// directly inside it is transcribed. The encoding, sample rate and channel
+// count of FLAC and WAV files are read from their headers.
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	speech "cloud.google.com/go/speech/apiv1"
-	"golang.org/x/net/context"
 	speechpb "google.golang.org/genproto/googleapis/cloud/speech/v1"
 )
 
+// audioExts are the file extensions transcribed when reading a directory.
+var audioExts = map[string]bool{
+	".flac": true,
+	".wav":  true,
+	".raw":  true,
+	".pcm":  true,
+	".ogg":  true,
+	".opus": true,
+	".amr":  true,
+	".awb":  true,
+	".spx":  true,
+}
+
 func main() {
+	var (
+		encoding        = flag.String("encoding", "", "audio encoding, detected if empty: "+strings.Join(encodings(), ", "))
+		rate            = flag.Int("rate", 0, "sample rate in hertz, detected if 0")
+		channels        = flag.Int("channels", 0, "number of audio channels, detected if 0")
+		lang            = flag.String("lang", "ja-JP", "BCP-47 language code of the audio")
+		altLangs        = flag.String("alt-langs", "", "comma-separated language codes the audio may also be in")
+		maxAlternatives = flag.Int("max-alternatives", 1, "maximum number of transcriptions to return for each result")
+		profanityFilter = flag.Bool("profanity-filter", false, "mask profanities in transcriptions")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: transcribe [flags] path...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	opts := options{
+		format: audioFormat{
+			Encoding:   strings.ToLower(*encoding),
+			SampleRate: *rate,
+			Channels:   *channels,
+		},
+		lang:            *lang,
+		maxAlternatives: *maxAlternatives,
+		profanityFilter: *profanityFilter,
+	}
+	if _, ok := speechpb.RecognitionConfig_AudioEncoding_value[strings.ToUpper(*encoding)]; *encoding != "" && !ok {
+		log.Fatalf("unknown encoding %q", *encoding)
+	}
+	if *altLangs != "" {
+		opts.altLangs = strings.Split(*altLangs, ",")
+	}
+
+	paths, err := expand(flag.Args())
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	ctx := context.Background()
 	// speech.NewClient assumes an environment variable
 	// GOOGLE_APPLICATION_CREDENTIALS that points to the service-account.json
@@ -21,31 +87,112 @@ func main() {
 	if err != nil {
 		log.Fatalf("failed to create client: %v", err)
 	}
+	defer client.Close()
 
+	failed := false
+	for _, path := range paths {
+		if len(paths) > 1 {
+			fmt.Printf("== %s ==\n", path)
+		}
+		if err := transcribe(ctx, client, path, opts); err != nil {
+			log.Printf("%s: %v", path, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// options describe the audio to transcribe and how to transcribe it.
+type options struct {
+	// format is the requested audio format. Zero fields are detected.
+	format          audioFormat
+	lang            string
+	altLangs        []string
+	maxAlternatives int
+	profanityFilter bool
+}
+
+// transcribe prints the transcription of the audio file at path.
+func transcribe(ctx context.Context, client *speech.Client, path string, opts options) error {
 	// Read a local audio file into memory.
-	data, err := ioutil.ReadFile("nhk-japanese.flac")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %v", err)
+	}
+	format, err := resolveFormat(opts.format, data)
 	if err != nil {
-		log.Fatalf("failed to read file: %v", err)
+		return err
 	}
 
 	resp, err := client.Recognize(ctx, &speechpb.RecognizeRequest{
 		Config: &speechpb.RecognitionConfig{
-			Encoding:        speechpb.RecognitionConfig_FLAC,
-			SampleRateHertz: int32(16000), // Assume a sample rate of 16k Hz
-			LanguageCode:    "ja-JP",      // Hard code Japanese as the sample's language.
+			Encoding:                 speechpb.RecognitionConfig_AudioEncoding(speechpb.RecognitionConfig_AudioEncoding_value[strings.ToUpper(format.Encoding)]),
+			SampleRateHertz:          int32(format.SampleRate),
+			AudioChannelCount:        int32(format.Channels),
+			LanguageCode:             opts.lang,
+			AlternativeLanguageCodes: opts.altLangs,
+			MaxAlternatives:          int32(opts.maxAlternatives),
+			ProfanityFilter:          opts.profanityFilter,
 		},
 		Audio: &speechpb.RecognitionAudio{
 			AudioSource: &speechpb.RecognitionAudio_Content{Content: data},
 		},
 	})
 	if err != nil {
-		log.Fatalf("failed to recognize: %v", err)
+		return fmt.Errorf("failed to recognize: %v", err)
 	}
 
-	// Print all results, in order of confidence of accurancy.
+	// Print all results, in order of confidence of accuracy.
 	for _, result := range resp.Results {
 		for _, alt := range result.Alternatives {
 			fmt.Printf("\"%v\" (confidence=%3f)\n", alt.Transcript, alt.Confidence)
 		}
 	}
+	return nil
+}
+
+// expand replaces each directory in paths with the audio files it contains. A
+// directory without any audio files is an error, since it most likely names
+// the wrong directory.
+func expand(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		// ReadDir returns entries sorted by name.
+		n := len(files)
+		for _, e := range entries {
+			if e.Type().IsRegular() && audioExts[strings.ToLower(filepath.Ext(e.Name()))] {
+				files = append(files, filepath.Join(path, e.Name()))
+			}
+		}
+		if len(files) == n {
+			return nil, fmt.Errorf("no audio files in %s", path)
+		}
+	}
+	return files, nil
+}
+
+// encodings returns the names of the supported audio encodings.
+func encodings() []string {
+	var names []string
+	for name, v := range speechpb.RecognitionConfig_AudioEncoding_value {
+		if v != int32(speechpb.RecognitionConfig_ENCODING_UNSPECIFIED) {
+			names = append(names, strings.ToLower(name))
+		}
+	}
+	sort.Strings(names)
+	return names
 }
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.wav", "a.FLAC", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub.wav"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	empty := t.TempDir()
+	single := filepath.Join(dir, "notes.txt")
+
+	got, err := expand([]string{single, dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{single, filepath.Join(dir, "a.FLAC"), filepath.Join(dir, "b.wav")}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+
+	if _, err := expand([]string{dir, empty}); err == nil {
+		t.Fatalf("want an error for %s, which has no audio files", empty)
+	}
+	if _, err := expand([]string{filepath.Join(dir, "missing.wav")}); err == nil {
+		t.Fatal("want an error for a missing file")
+	}
+}
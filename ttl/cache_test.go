@@ -0,0 +1,86 @@
+package ttl
+
+import (
+	"testing"
+	"time"
+)
+
+type session struct {
+	user  string
+	roles []string
+}
+
+func TestCacheOfStructs(t *testing.T) {
+	var evicted []int
+	c := NewCache[int, session](time.Hour,
+		WithMaxBytes(10),
+		WithSizer(func(s session) int64 { return int64(len(s.user)) }),
+		WithOnEvict(func(key int, _ session, reason EvictReason) {
+			if reason == Capacity {
+				evicted = append(evicted, key)
+			}
+		}),
+	)
+	defer c.Close()
+
+	c.Set(1, session{user: "gopher", roles: []string{"admin"}})
+	c.Set(2, session{user: "ferris"})
+
+	if _, ok := c.Get(1); ok {
+		t.Fatal("want key 1 evicted to make room for key 2")
+	}
+	got, ok := c.Get(2)
+	if !ok || got.user != "ferris" {
+		t.Fatalf("want ferris, got %+v (present=%v)", got, ok)
+	}
+	if len(evicted) != 1 || evicted[0] != 1 {
+		t.Fatalf("want key 1 reported as evicted, got %v", evicted)
+	}
+}
+
+func TestCacheCompareAndSwap(t *testing.T) {
+	c := NewCache[string, int](time.Hour)
+	defer c.Close()
+
+	c.Set("n", 1)
+	if !c.CompareAndSwap("n", 1, 2) || c.CompareAndSwap("n", 1, 3) {
+		t.Fatal("want only the swap from the current value to succeed")
+	}
+
+	s := NewCache[string, session](time.Hour,
+		WithEqual(func(a, b session) bool { return a.user == b.user }))
+	defer s.Close()
+	s.Set("k", session{user: "gopher"})
+	if !s.CompareAndSwap("k", session{user: "gopher"}, session{user: "ferris"}) {
+		t.Fatal("want WithEqual to be used to compare values")
+	}
+}
+
+func TestCacheCompareAndSwapWithoutEqual(t *testing.T) {
+	c := NewCache[string, session](time.Hour)
+	defer c.Close()
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("want CompareAndSwap to panic without a way to compare values")
+		}
+	}()
+	c.CompareAndSwap("k", session{}, session{})
+}
+
+func TestNewCacheRejectsMistypedOptions(t *testing.T) {
+	tests := map[string][]Option{
+		"OnEvict":  {WithOnEvict(func(string, string, EvictReason) {})},
+		"MaxBytes": {WithMaxBytes(10)},
+	}
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("want NewCache to panic")
+				}
+			}()
+			NewCache[string, session](time.Hour, opts...).Close()
+		})
+	}
+}
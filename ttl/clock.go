@@ -0,0 +1,113 @@
+package ttl
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides a Cache with the current time and with tickers to drive its
+// expiry worker. The default Clock uses the time package; tests may supply a
+// FakeClock to control time directly.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks on a channel at intervals, as a time.Ticker does.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// realClock is the Clock backed by the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+// realTicker adapts a time.Ticker to the Ticker interface.
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
+
+// FakeClock is a Clock whose time only moves when Advance is called. Tickers
+// created by a FakeClock fire as Advance moves time past their deadlines.
+// A FakeClock is safe for concurrent use.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+// NewFakeClock creates a FakeClock whose current time is start.
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+// Now returns the fake current time.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTicker creates a Ticker that fires every d of fake time.
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("ttl: non-positive interval for NewTicker")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTicker{
+		c:      make(chan time.Time, 1),
+		clock:  c,
+		period: d,
+		next:   c.now.Add(d),
+	}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Advance moves the clock forward by d and fires any tickers that come due.
+// As with time.Ticker, a ticker whose channel is still full drops the tick.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	for _, t := range c.tickers {
+		for !t.next.After(c.now) {
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
+
+// fakeTicker is a Ticker created by a FakeClock.
+type fakeTicker struct {
+	c      chan time.Time
+	clock  *FakeClock
+	period time.Duration
+	next   time.Time // guarded by clock.mu
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.c }
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	for i, other := range t.clock.tickers {
+		if other == t {
+			t.clock.tickers = append(t.clock.tickers[:i], t.clock.tickers[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package ttl
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClockTicker(t *testing.T) {
+	start := time.Date(2018, 4, 10, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	ticker := clock.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	clock.Advance(999 * time.Millisecond)
+	select {
+	case <-ticker.C():
+		t.Fatal("want no tick before the interval has elapsed")
+	default:
+	}
+
+	clock.Advance(time.Millisecond)
+	select {
+	case got := <-ticker.C():
+		if want := start.Add(time.Second); !got.Equal(want) {
+			t.Fatalf("want tick at %v, got %v", want, got)
+		}
+	default:
+		t.Fatal("want a tick once the interval has elapsed")
+	}
+
+	if got, want := clock.Now(), start.Add(time.Second); !got.Equal(want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
+
+func TestFakeClockStoppedTicker(t *testing.T) {
+	clock := NewFakeClock(time.Time{})
+	ticker := clock.NewTicker(time.Second)
+	ticker.Stop()
+
+	clock.Advance(time.Hour)
+	select {
+	case <-ticker.C():
+		t.Fatal("want a stopped ticker not to fire")
+	default:
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Sample is one measurement of the process taken during a soak test.
+type Sample struct {
+	Elapsed      time.Duration `json:"elapsed_ns"`
+	HeapAlloc    uint64        `json:"heap_alloc"`
+	NumGoroutine int           `json:"num_goroutine"`
+}
+
+// Verdict is the outcome of looking for leaks in a series of samples.
+type Verdict struct {
+	Leak    bool     `json:"leak"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// thresholds configures detectLeak.
+type thresholds struct {
+	// warmup is the fraction of samples ignored at the start of the run,
+	// while the heap and goroutines settle.
+	warmup float64
+	// windows is the number of equal windows the remaining samples are
+	// split into.
+	windows int
+	// heapGrowth is the relative growth of the heap, and goroutineGrowth
+	// the absolute growth in goroutines, that count as a leak.
+	heapGrowth      float64
+	goroutineGrowth int
+}
+
+// detectLeak looks for monotonic growth in samples. After the warmup, samples
+// are split into windows and the minimum of each window is taken, which
+// smooths out the sawtooth of a garbage-collected heap. A measure leaks if its
+// minimum rises in every window and the total rise exceeds its threshold.
+func detectLeak(samples []Sample, t thresholds) Verdict {
+	samples = samples[int(float64(len(samples))*t.warmup):]
+	if t.windows < 2 || len(samples) < t.windows {
+		return Verdict{}
+	}
+
+	heap := make([]float64, t.windows)
+	goroutines := make([]float64, t.windows)
+	size := len(samples) / t.windows
+	for w := 0; w < t.windows; w++ {
+		window := samples[w*size : (w+1)*size]
+		heap[w] = minOf(window, func(s Sample) float64 { return float64(s.HeapAlloc) })
+		goroutines[w] = minOf(window, func(s Sample) float64 { return float64(s.NumGoroutine) })
+	}
+
+	var v Verdict
+	first, last := heap[0], heap[len(heap)-1]
+	if increasing(heap) && last-first > first*t.heapGrowth {
+		v.Leak = true
+		v.Reasons = append(v.Reasons, fmt.Sprintf("heap grew from %.0f to %.0f bytes", first, last))
+	}
+	first, last = goroutines[0], goroutines[len(goroutines)-1]
+	if increasing(goroutines) && last-first > float64(t.goroutineGrowth) {
+		v.Leak = true
+		v.Reasons = append(v.Reasons, fmt.Sprintf("goroutines grew from %.0f to %.0f", first, last))
+	}
+	return v
+}
+
+// minOf returns the smallest value of f over samples.
+func minOf(samples []Sample, f func(Sample) float64) float64 {
+	m := f(samples[0])
+	for _, s := range samples[1:] {
+		m = min(m, f(s))
+	}
+	return m
+}
+
+// increasing reports whether every value is greater than the one before it.
+func increasing(values []float64) bool {
+	for i := 1; i < len(values); i++ {
+		if values[i] <= values[i-1] {
+			return false
+		}
+	}
+	return true
+}
+
+// writeCSV writes samples to w with a header row.
+func writeCSV(w io.Writer, samples []Sample) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"elapsed_seconds", "heap_alloc", "num_goroutine"})
+	for _, s := range samples {
+		cw.Write([]string{
+			strconv.FormatFloat(s.Elapsed.Seconds(), 'f', 3, 64),
+			strconv.FormatUint(s.HeapAlloc, 10),
+			strconv.Itoa(s.NumGoroutine),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var defaultThresholds = thresholds{
+	warmup:          0.25,
+	windows:         4,
+	heapGrowth:      0.2,
+	goroutineGrowth: 10,
+}
+
+// series builds samples whose heap and goroutine counts are given by f.
+func series(n int, f func(i int) (heap uint64, goroutines int)) []Sample {
+	samples := make([]Sample, n)
+	for i := range samples {
+		heap, g := f(i)
+		samples[i] = Sample{Elapsed: time.Duration(i) * time.Second, HeapAlloc: heap, NumGoroutine: g}
+	}
+	return samples
+}
+
+func TestDetectLeak(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []Sample
+		leak    bool
+	}{
+		{
+			name: "steady",
+			samples: series(40, func(i int) (uint64, int) {
+				// A sawtooth heap that is collected back to the same floor.
+				return 1000 + uint64(i%5)*200, 10
+			}),
+		},
+		{
+			name: "leaking goroutines",
+			samples: series(40, func(i int) (uint64, int) {
+				return 1000, 10 + i
+			}),
+			leak: true,
+		},
+		{
+			name: "growing heap",
+			samples: series(40, func(i int) (uint64, int) {
+				return 1000 + uint64(i)*100 + uint64(i%3)*50, 10
+			}),
+			leak: true,
+		},
+		{
+			name: "growth during warmup only",
+			samples: series(40, func(i int) (uint64, int) {
+				return 1000 + uint64(min(i, 8))*1000, 10 + min(i, 8)*10
+			}),
+		},
+		{
+			name:    "too few samples",
+			samples: series(3, func(i int) (uint64, int) { return uint64(i) * 1000, i * 100 }),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v := detectLeak(tc.samples, defaultThresholds)
+			if v.Leak != tc.leak {
+				t.Fatalf("want leak=%v, got %+v", tc.leak, v)
+			}
+		})
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	samples := []Sample{{Elapsed: 1500 * time.Millisecond, HeapAlloc: 2048, NumGoroutine: 7}}
+	if err := writeCSV(&buf, samples); err != nil {
+		t.Fatalf("writeCSV: %v", err)
+	}
+	want := "elapsed_seconds,heap_alloc,num_goroutine\n1.500,2048,7\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+}
+
+func TestWriteJSONReport(t *testing.T) {
+	var buf bytes.Buffer
+	v := Verdict{Leak: true, Reasons: []string{"goroutines grew from 10 to 40"}}
+	if err := writeReport(&buf, "json", nil, v); err != nil {
+		t.Fatalf("writeReport: %v", err)
+	}
+	if got := buf.String(); !strings.Contains(got, `"leak": true`) || !strings.Contains(got, "goroutines grew") {
+		t.Fatalf("want verdict in report, got %s", got)
+	}
+}
+
+func TestRunWritesReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	_, err := run([]string{
+		"-duration", "300ms",
+		"-sample", "20ms",
+		"-concurrency", "2",
+		"-scenario", "shared",
+		"-format", "json",
+		"-o", path,
+	})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report struct {
+		Samples []Sample `json:"samples"`
+	}
+	if err := json.Unmarshal(b, &report); err != nil {
+		t.Fatalf("want a complete report, got %v", err)
+	}
+	if len(report.Samples) == 0 {
+		t.Fatal("want samples in report")
+	}
+}
+
+func TestRunRejectsBadFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-sample", "0"},
+		{"-sample", "-1s"},
+		{"-warmup", "-0.5"},
+		{"-warmup", "1.5"},
+	} {
+		if _, err := run(append([]string{"-duration", "10ms"}, args...)); err == nil {
+			t.Errorf("%v: want an error, got nil", args)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+// The soak binary exercises ttl.Map under sustained load while sampling the
+// size of the heap and the number of goroutines. When the run ends it reports
+// the samples and exits with status 1 if either grew steadily, which points to
+// a leak such as a worker goroutine that outlives its map.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gobuildit/gobuildit/ttl"
+)
+
+func main() {
+	leak, err := run(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if leak {
+		os.Exit(1)
+	}
+}
+
+// run runs a soak test configured by the command-line arguments args, writes
+// its report, and reports whether a leak was detected.
+func run(args []string) (leak bool, err error) {
+	flags := flag.NewFlagSet("soak", flag.ExitOnError)
+	var (
+		duration    = flags.Duration("duration", time.Minute, "how long to run")
+		interval    = flags.Duration("sample", time.Second, "how often to sample the heap and goroutines")
+		concurrency = flags.Int("concurrency", runtime.NumCPU(), "number of goroutines generating load")
+		scenario    = flags.String("scenario", "churn", "load to generate: churn creates and closes a map per operation, shared hammers one map")
+		dist        = flags.String("dist", "uniform", "key distribution: uniform, zipf or sequential")
+		keys        = flags.Int("keys", 10000, "number of distinct keys")
+		valueSize   = flags.Int("value-size", 64, "size of each value in bytes")
+		expiration  = flags.Duration("ttl", 5*time.Minute, "expiration of each map")
+		noClose     = flags.Bool("no-close", false, "never close maps in the churn scenario, to check that dropped maps are still reclaimed")
+		warmup      = flags.Float64("warmup", 0.25, "fraction of samples to ignore before looking for growth")
+		windows     = flags.Int("windows", 4, "number of windows the remaining samples are split into")
+		heapGrowth  = flags.Float64("heap-growth", 0.2, "relative heap growth across the run that counts as a leak")
+		goGrowth    = flags.Int("goroutine-growth", 10, "goroutine growth across the run that counts as a leak")
+		format      = flags.String("format", "csv", "report format: csv or json")
+		out         = flags.String("o", "", "file to write the report to; standard output if empty")
+	)
+	flags.Parse(args)
+	if *interval <= 0 {
+		return false, fmt.Errorf("-sample must be positive, got %v", *interval)
+	}
+	if *warmup < 0 || *warmup > 1 {
+		return false, fmt.Errorf("-warmup must be between 0 and 1, got %v", *warmup)
+	}
+
+	nextKey, err := keyGenerator(*dist, *keys)
+	if err != nil {
+		return false, err
+	}
+	var op func(key string)
+	switch *scenario {
+	case "churn":
+		op = churn(*expiration, *valueSize, !*noClose)
+	case "shared":
+		m := ttl.NewMap(*expiration)
+		defer m.Close()
+		op = shared(m, *valueSize)
+	default:
+		return false, fmt.Errorf("unknown scenario %q", *scenario)
+	}
+
+	baseline := runtime.NumGoroutine()
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+
+	var (
+		wg  sync.WaitGroup
+		ops atomic.Int64
+	)
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				op(nextKey())
+				ops.Add(1)
+			}
+		}()
+	}
+	samples := sample(ctx, *interval)
+	wg.Wait()
+	log.Printf("completed %d operations in %s", ops.Load(), *duration)
+
+	verdict := detectLeak(samples, thresholds{
+		warmup:          *warmup,
+		windows:         *windows,
+		heapGrowth:      *heapGrowth,
+		goroutineGrowth: *goGrowth,
+	})
+	// Once the load stops every map is closed or unreachable, so their
+	// goroutines should exit and leave the count where it started.
+	if n := settle(baseline+*goGrowth, 10*time.Second); n > baseline+*goGrowth {
+		verdict.Leak = true
+		verdict.Reasons = append(verdict.Reasons, fmt.Sprintf("%d goroutines still running after the load stopped, started with %d", n, baseline))
+	}
+
+	if err := report(*out, *format, samples, verdict); err != nil {
+		return false, err
+	}
+
+	if verdict.Leak {
+		for _, r := range verdict.Reasons {
+			log.Printf("leak detected: %s", r)
+		}
+		return true, nil
+	}
+	log.Print("no leak detected")
+	return false, nil
+}
+
+// report writes the report to the file at path, or to standard output if path
+// is empty.
+func report(path, format string, samples []Sample, v Verdict) error {
+	if path == "" {
+		return writeReport(os.Stdout, format, samples, v)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeReport(f, format, samples, v); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// churn returns an operation that creates a map, sets a key, reads it back and
+// then closes the map, as ttl/main.go once did in a tight loop.
+func churn(expiration time.Duration, valueSize int, close bool) func(string) {
+	value := make([]byte, valueSize)
+	return func(key string) {
+		m := ttl.NewMap(expiration)
+		m.Set(key, value)
+		if _, ok := m.Get(key); !ok {
+			panic("no value present")
+		}
+		if close {
+			m.Close()
+		}
+		// m goes out of scope
+	}
+}
+
+// shared returns an operation that reads key from m, setting it on a miss.
+func shared(m *ttl.Map, valueSize int) func(string) {
+	value := make([]byte, valueSize)
+	return func(key string) {
+		if _, ok := m.Get(key); !ok {
+			m.Set(key, value)
+		}
+	}
+}
+
+// keyGenerator returns a function producing keys in [0, n) drawn from the
+// named distribution. The function is safe for concurrent use.
+func keyGenerator(dist string, n int) (func() string, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("need at least one key, got %d", n)
+	}
+	switch dist {
+	case "uniform":
+		return func() string { return strconv.Itoa(rand.IntN(n)) }, nil
+	case "sequential":
+		var next atomic.Uint64
+		return func() string { return strconv.FormatUint((next.Add(1)-1)%uint64(n), 10) }, nil
+	case "zipf":
+		// A Zipf generator is not safe for concurrent use, so guard one.
+		var mu sync.Mutex
+		z := rand.NewZipf(rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())), 1.1, 1, uint64(n-1))
+		return func() string {
+			mu.Lock()
+			k := z.Uint64()
+			mu.Unlock()
+			return strconv.FormatUint(k, 10)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown key distribution %q", dist)
+	}
+}
+
+// sample records the heap and goroutine count every interval until ctx is
+// done. The heap is measured after a forced GC so that samples reflect live
+// memory rather than garbage awaiting collection.
+func sample(ctx context.Context, interval time.Duration) []Sample {
+	start := time.Now()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var samples []Sample
+	var stats runtime.MemStats
+	for {
+		select {
+		case <-ctx.Done():
+			return samples
+		case now := <-ticker.C:
+			if ctx.Err() != nil {
+				// Don't record workers winding down as part of the run.
+				return samples
+			}
+			runtime.GC()
+			runtime.ReadMemStats(&stats)
+			samples = append(samples, Sample{
+				Elapsed:      now.Sub(start),
+				HeapAlloc:    stats.HeapAlloc,
+				NumGoroutine: runtime.NumGoroutine(),
+			})
+		}
+	}
+}
+
+// settle collects garbage until at most want goroutines are running or timeout
+// passes, and returns the number left running.
+func settle(want int, timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	for {
+		runtime.GC()
+		n := runtime.NumGoroutine()
+		if n <= want || time.Now().After(deadline) {
+			return n
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// writeReport writes the samples and verdict to w in the given format. The CSV
+// format holds only the samples; the verdict is logged separately.
+func writeReport(w io.Writer, format string, samples []Sample, v Verdict) error {
+	switch format {
+	case "csv":
+		return writeCSV(w, samples)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			Verdict
+			Samples []Sample `json:"samples"`
+		}{v, samples})
+	default:
+		return fmt.Errorf("unknown report format %q", format)
+	}
+}
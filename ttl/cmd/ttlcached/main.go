@@ -0,0 +1,37 @@
+// The ttlcached binary serves a ttl.Cache over TCP using a subset of the
+// memcached text protocol, so memcached clients and tools can share the cache
+// between processes.
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/gobuildit/gobuildit/ttl"
+	"github.com/gobuildit/gobuildit/ttl/memcache"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:11211", "address to listen on")
+	maxBytes := flag.Int64("max-bytes", 64*1024*1024, "total size of values to hold before evicting; 0 for no limit")
+	maxEntries := flag.Int("max-entries", 0, "number of keys to hold before evicting; 0 for no limit")
+	maxItem := flag.Int("max-item-size", memcache.DefaultMaxItemSize, "largest value accepted by set")
+	lfu := flag.Bool("lfu", false, "evict the least frequently used keys instead of the least recently used")
+	flag.Parse()
+
+	policy := ttl.LRU
+	if *lfu {
+		policy = ttl.LFU
+	}
+	s := memcache.NewServer(
+		ttl.WithMaxBytes(*maxBytes),
+		ttl.WithMaxEntries(*maxEntries),
+		ttl.WithEvictionPolicy(policy),
+	)
+	s.MaxItemSize = *maxItem
+
+	log.Printf("listening on %s", *addr)
+	if err := s.ListenAndServe(*addr); err != nil {
+		log.Fatalf("failed to serve: %s", err)
+	}
+}
@@ -0,0 +1,168 @@
+package ttl
+
+import (
+	"container/heap"
+	"container/list"
+)
+
+// EvictionPolicy selects which entry a size-bounded Cache discards when a write
+// would take it over capacity.
+type EvictionPolicy int
+
+const (
+	// LRU evicts the least recently used entry.
+	LRU EvictionPolicy = iota
+	// LFU evicts the least frequently used entry. Among entries used equally
+	// often, the least recently used is evicted first.
+	LFU
+)
+
+// newEvictor creates the evictor that implements p.
+func newEvictor[K comparable, V any](p EvictionPolicy) evictor[K, V] {
+	switch p {
+	case LRU:
+		return &lruEvictor[K, V]{order: list.New()}
+	case LFU:
+		return &lfuEvictor[K, V]{}
+	default:
+		panic("ttl: unknown eviction policy")
+	}
+}
+
+// evictor tracks how entries are used and nominates one for eviction. Calls
+// are serialized by the Cache.
+type evictor[K comparable, V any] interface {
+	// add starts tracking a newly stored entry.
+	add(e *entry[K, V])
+	// access records a read or overwrite of a tracked entry.
+	access(e *entry[K, V])
+	// remove stops tracking an entry.
+	remove(e *entry[K, V])
+	// victim returns the entry to evict next, or nil if none are tracked.
+	victim() *entry[K, V]
+}
+
+// lruEvictor keeps entries in a list ordered from most to least recently
+// used.
+type lruEvictor[K comparable, V any] struct {
+	order *list.List
+}
+
+func (l *lruEvictor[K, V]) add(e *entry[K, V])    { e.elem = l.order.PushFront(e) }
+func (l *lruEvictor[K, V]) access(e *entry[K, V]) { l.order.MoveToFront(e.elem) }
+
+func (l *lruEvictor[K, V]) remove(e *entry[K, V]) {
+	l.order.Remove(e.elem)
+	e.elem = nil
+}
+
+func (l *lruEvictor[K, V]) victim() *entry[K, V] {
+	back := l.order.Back()
+	if back == nil {
+		return nil
+	}
+	return back.Value.(*entry[K, V])
+}
+
+// lfuEvictor keeps entries in a min-heap ordered by use count and then by
+// the time of last use.
+type lfuEvictor[K comparable, V any] struct {
+	entries lfuHeap[K, V]
+	// clock is a logical clock that orders uses without consulting the
+	// Cache's Clock.
+	clock uint64
+}
+
+func (l *lfuEvictor[K, V]) add(e *entry[K, V]) {
+	l.clock++
+	e.uses, e.lastUse = 1, l.clock
+	heap.Push(&l.entries, e)
+}
+
+func (l *lfuEvictor[K, V]) access(e *entry[K, V]) {
+	l.clock++
+	e.uses++
+	e.lastUse = l.clock
+	heap.Fix(&l.entries, e.lfuIndex)
+}
+
+func (l *lfuEvictor[K, V]) remove(e *entry[K, V]) { heap.Remove(&l.entries, e.lfuIndex) }
+
+func (l *lfuEvictor[K, V]) victim() *entry[K, V] {
+	if len(l.entries) == 0 {
+		return nil
+	}
+	return l.entries[0]
+}
+
+// lfuHeap implements heap.Interface for lfuEvictor.
+type lfuHeap[K comparable, V any] []*entry[K, V]
+
+func (h lfuHeap[K, V]) Len() int { return len(h) }
+
+func (h lfuHeap[K, V]) Less(i, j int) bool {
+	if h[i].uses != h[j].uses {
+		return h[i].uses < h[j].uses
+	}
+	return h[i].lastUse < h[j].lastUse
+}
+
+func (h lfuHeap[K, V]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].lfuIndex = i
+	h[j].lfuIndex = j
+}
+
+func (h *lfuHeap[K, V]) Push(x any) {
+	e := x.(*entry[K, V])
+	e.lfuIndex = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *lfuHeap[K, V]) Pop() any {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	e.lfuIndex = -1
+	*h = old[:n-1]
+	return e
+}
+
+// EvictReason describes why a key left a Cache.
+type EvictReason int
+
+const (
+	// Expired means the key's expiration passed.
+	Expired EvictReason = iota
+	// Capacity means the key was evicted to keep a size-bounded Cache
+	// within its bounds.
+	Capacity
+	// Deleted means the key was removed by Delete.
+	Deleted
+	// Replaced means the key's value was overwritten by a new one. The
+	// callback receives the old value.
+	Replaced
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case Expired:
+		return "expired"
+	case Capacity:
+		return "capacity"
+	case Deleted:
+		return "deleted"
+	case Replaced:
+		return "replaced"
+	default:
+		return "unknown"
+	}
+}
+
+// eviction is a notification queued for a Cache's OnEvict callback.
+type eviction[K comparable, V any] struct {
+	key    K
+	value  V
+	reason EvictReason
+}
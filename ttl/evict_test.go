@@ -0,0 +1,170 @@
+package ttl
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMaxEntriesLRU(t *testing.T) {
+	m := NewMap(time.Hour, WithMaxEntries(2), WithEvictionPolicy(LRU))
+	defer m.Close()
+
+	m.Set("a", []byte("1"))
+	m.Set("b", []byte("2"))
+	m.Get("a") // b is now least recently used
+	m.Set("c", []byte("3"))
+
+	assertKeys(t, m, []string{"a", "c"}, []string{"b"})
+}
+
+func TestMaxEntriesLFU(t *testing.T) {
+	m := NewMap(time.Hour, WithMaxEntries(2), WithEvictionPolicy(LFU))
+	defer m.Close()
+
+	m.Set("a", []byte("1"))
+	m.Set("b", []byte("2"))
+	m.Get("a")
+	m.Get("a")
+	m.Get("b") // b is the most recent, but a is used more often
+	m.Set("c", []byte("3"))
+
+	assertKeys(t, m, []string{"a", "c"}, []string{"b"})
+}
+
+func TestMaxBytes(t *testing.T) {
+	m := NewMap(time.Hour, WithMaxBytes(10))
+	defer m.Close()
+
+	m.Set("a", []byte("12345"))
+	m.Set("b", []byte("12345"))
+	m.Set("a", []byte("123")) // shrinking a value frees space
+	m.Set("c", []byte("12"))
+	assertKeys(t, m, []string{"a", "b", "c"}, nil)
+
+	m.Set("d", []byte("1234"))
+	assertKeys(t, m, []string{"c", "d"}, []string{"a", "b"})
+	if m.bytes != 6 {
+		t.Fatalf("want 6 bytes stored, got %d", m.bytes)
+	}
+
+	m.Set("c", []byte("12345678901"))
+	assertKeys(t, m, []string{"d"}, []string{"c"})
+	if m.bytes != 4 {
+		t.Fatalf("want 4 bytes stored, got %d", m.bytes)
+	}
+}
+
+func TestCapacityPrefersExpired(t *testing.T) {
+	clock := NewFakeClock(time.Date(2018, 4, 10, 0, 0, 0, 0, time.UTC))
+	m := NewMap(time.Hour, WithClock(clock), WithMaxEntries(2))
+	defer m.Close()
+
+	m.Set("old", []byte("1"))
+	m.SetWithTTL("short", []byte("2"), time.Second)
+	clock.Advance(time.Second)
+	m.Set("new", []byte("3"))
+
+	assertKeys(t, m, []string{"old", "new"}, []string{"short"})
+}
+
+// TestConcurrentBoundedAccess runs readers and writers against bounded maps so
+// the race detector sees accesses recorded under the read lock.
+func TestConcurrentBoundedAccess(t *testing.T) {
+	for _, p := range []EvictionPolicy{LRU, LFU} {
+		m := NewMap(time.Hour, WithMaxEntries(8), WithEvictionPolicy(p))
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 500; j++ {
+					key := strconv.Itoa((i + j) % 16)
+					m.Set(key, []byte(key))
+					m.Get(key)
+				}
+			}(i)
+		}
+		wg.Wait()
+		if n := len(m.data); n > 8 {
+			t.Errorf("want at most 8 entries, got %d", n)
+		}
+		m.Close()
+	}
+}
+
+// assertKeys checks that every key in present can be read from m and that no
+// key in absent can.
+func assertKeys(t *testing.T, m *Map, present, absent []string) {
+	t.Helper()
+	for _, key := range present {
+		if _, ok := m.Get(key); !ok {
+			t.Errorf("want %q to be present", key)
+		}
+	}
+	for _, key := range absent {
+		if _, ok := m.Get(key); ok {
+			t.Errorf("want %q to be absent", key)
+		}
+	}
+}
+
+func TestOnEvict(t *testing.T) {
+	type call struct {
+		key, value string
+		reason     EvictReason
+	}
+	var calls []call
+	clock := NewFakeClock(time.Date(2018, 4, 10, 0, 0, 0, 0, time.UTC))
+	m := NewMap(time.Hour,
+		WithClock(clock),
+		WithMaxEntries(2),
+		WithOnEvict(func(key string, value []byte, reason EvictReason) {
+			calls = append(calls, call{key, string(value), reason})
+		}),
+	)
+	defer m.Close()
+
+	m.Set("a", []byte("1"))
+	m.Set("a", []byte("2"))
+	m.Delete("a")
+	m.Set("b", []byte("3"))
+	m.Set("c", []byte("4"))
+	m.Set("d", []byte("5"))
+	clock.Advance(time.Hour)
+	m.Get("c")
+	m.removeExpired()
+
+	want := []call{
+		{"a", "1", Replaced},
+		{"a", "2", Deleted},
+		{"b", "3", Capacity},
+		{"c", "4", Expired},
+		{"d", "5", Expired},
+	}
+	if len(calls) != len(want) {
+		t.Fatalf("want %v, got %v", want, calls)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Fatalf("want %v, got %v", want, calls)
+		}
+	}
+}
+
+func TestOnEvictReentrant(t *testing.T) {
+	var m *Map
+	m = NewMap(time.Hour, WithOnEvict(func(key string, value []byte, reason EvictReason) {
+		if reason == Deleted {
+			m.Set("archived-"+key, value)
+		}
+	}))
+	defer m.Close()
+
+	m.Set("key", []byte("v"))
+	m.Delete("key")
+	if v, ok := m.Get("archived-key"); !ok || string(v) != "v" {
+		t.Fatalf("want callback to store archived value, got %q (present=%v)", v, ok)
+	}
+}
@@ -0,0 +1,113 @@
+package ttl
+
+import (
+	"errors"
+	"sync"
+	"time"
+	"weak"
+)
+
+// ErrClosed is returned by Close when a Cache or Janitor has already been
+// closed, and by the Cache methods that write to or persist a closed Cache.
+var ErrClosed = errors.New("ttl: already closed")
+
+// Janitor expires stale keys for many caches from a single goroutine. Caches
+// are added to a Janitor with the WithJanitor option and removed when they are
+// closed or become unreachable.
+type Janitor struct {
+	mu     sync.Mutex
+	sweeps map[uint64]func() bool
+	nextID uint64
+
+	done   chan struct{}
+	closed bool // guarded by mu
+}
+
+// NewJanitor creates a Janitor and starts its goroutine, which sweeps every
+// cache using it once per interval. A nil clock uses the system clock.
+func NewJanitor(interval time.Duration, clock Clock) *Janitor {
+	if clock == nil {
+		clock = realClock{}
+	}
+	j := &Janitor{
+		sweeps: make(map[uint64]func() bool),
+		done:   make(chan struct{}),
+	}
+
+	ticker := clock.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+				j.sweep()
+			case <-j.done:
+				return
+			}
+		}
+	}()
+	return j
+}
+
+// Close stops the Janitor's goroutine. Caches still using it no longer have
+// stale keys removed in the background, though reads continue to ignore them.
+// Calling Close more than once returns ErrClosed.
+func (j *Janitor) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.closed {
+		return ErrClosed
+	}
+	j.closed = true
+	close(j.done)
+	return nil
+}
+
+// add registers a sweep function and returns a function that unregisters it.
+// A sweep function reports false once its cache is gone.
+func (j *Janitor) add(sweep func() bool) (remove func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	id := j.nextID
+	j.nextID++
+	j.sweeps[id] = sweep
+	return func() {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		delete(j.sweeps, id)
+	}
+}
+
+// sweep runs every registered sweep function, dropping those whose caches are
+// gone. The functions run without j.mu held so that a cache being swept may be
+// closed concurrently.
+func (j *Janitor) sweep() {
+	j.mu.Lock()
+	sweeps := make(map[uint64]func() bool, len(j.sweeps))
+	for id, fn := range j.sweeps {
+		sweeps[id] = fn
+	}
+	j.mu.Unlock()
+
+	for id, fn := range sweeps {
+		if !fn() {
+			j.mu.Lock()
+			delete(j.sweeps, id)
+			j.mu.Unlock()
+		}
+	}
+}
+
+// sweeper returns a Janitor sweep function for c. It holds c weakly, so that
+// registering with a Janitor does not keep c reachable.
+func sweeper[K comparable, V any](c *Cache[K, V]) func() bool {
+	wp := weak.Make(c)
+	return func() bool {
+		c := wp.Value()
+		if c == nil {
+			return false
+		}
+		c.removeExpired()
+		return true
+	}
+}
@@ -0,0 +1,139 @@
+package ttl
+
+import (
+	"bytes"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCloseTwice(t *testing.T) {
+	m := NewMap(time.Hour)
+	if err := m.Close(); err != nil {
+		t.Fatalf("want first Close to succeed, got %v", err)
+	}
+	if err := m.Close(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("want %v, got %v", ErrClosed, err)
+	}
+
+	j := NewJanitor(time.Hour, nil)
+	if err := j.Close(); err != nil {
+		t.Fatalf("want first Close to succeed, got %v", err)
+	}
+	if err := j.Close(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("want %v, got %v", ErrClosed, err)
+	}
+}
+
+func TestUseAfterClose(t *testing.T) {
+	clock := NewFakeClock(time.Date(2018, 4, 10, 0, 0, 0, 0, time.UTC))
+	m := NewMap(time.Minute, WithClock(clock))
+	m.Set("a", []byte("1"))
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := m.Get("a"); !ok {
+		t.Fatal("want a to survive Close")
+	}
+	clock.Advance(time.Minute)
+	// With no worker left, writes remove expired keys.
+	m.Set("b", []byte("2"))
+	if n := len(m.data); n != 1 {
+		t.Fatalf("want 1 key stored, got %d", n)
+	}
+	if _, ok := m.Get("a"); ok {
+		t.Fatal("want a to expire after Close")
+	}
+
+	if err := m.Store("c", []byte("3"), time.Minute); !errors.Is(err, ErrClosed) {
+		t.Fatalf("want %v from Store, got %v", ErrClosed, err)
+	}
+	if _, ok := m.Get("c"); ok {
+		t.Fatal("want Store to store nothing after Close")
+	}
+	var buf bytes.Buffer
+	if err := m.Snapshot(&buf); !errors.Is(err, ErrClosed) {
+		t.Fatalf("want %v from Snapshot, got %v", ErrClosed, err)
+	}
+	src := NewMap(time.Minute)
+	defer src.Close()
+	src.Set("d", []byte("4"))
+	if err := src.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Restore(&buf); !errors.Is(err, ErrClosed) {
+		t.Fatalf("want %v from Restore, got %v", ErrClosed, err)
+	}
+}
+
+func TestWithoutJanitor(t *testing.T) {
+	before := runtime.NumGoroutine()
+	clock := NewFakeClock(time.Date(2018, 4, 10, 0, 0, 0, 0, time.UTC))
+	m := NewMap(time.Minute, WithClock(clock), WithoutJanitor())
+	defer m.Close()
+	if n := runtime.NumGoroutine(); n > before {
+		t.Fatalf("want no goroutine started, got %d more", n-before)
+	}
+
+	m.Set("a", []byte("1"))
+	clock.Advance(time.Minute)
+	m.Set("b", []byte("2"))
+	if n := len(m.data); n != 1 {
+		t.Fatalf("want the write to remove the expired key, got %d entries", n)
+	}
+}
+
+func TestSharedJanitor(t *testing.T) {
+	clock := NewFakeClock(time.Date(2018, 4, 10, 0, 0, 0, 0, time.UTC))
+	j := NewJanitor(time.Second, clock)
+	defer j.Close()
+
+	var caches []*Map
+	for i := 0; i < 3; i++ {
+		m := NewMap(time.Minute, WithClock(clock), WithJanitor(j))
+		defer m.Close()
+		m.Set("key", []byte("v"))
+		caches = append(caches, m)
+	}
+
+	clock.Advance(time.Minute)
+
+	// The janitor sweeps asynchronously after the tick.
+	deadline := time.Now().Add(5 * time.Second)
+	for _, m := range caches {
+		for {
+			m.mu.RLock()
+			n := len(m.data)
+			m.mu.RUnlock()
+			if n == 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("want the janitor to expire every cache's key, got %d entries", n)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+// TestUnreachableCacheReleasesWorker drops caches without closing them, as
+// cmd/soak does with -no-close, and checks that their workers still exit once
+// the caches are garbage collected.
+func TestUnreachableCacheReleasesWorker(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		m := NewMap(time.Hour)
+		m.Set("key", []byte("v"))
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("want workers to exit, got %d goroutines, started with %d", runtime.NumGoroutine(), before)
+		}
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,155 @@
+package ttl
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// LoadingMap is a read-through cache. On a miss it calls a loader to fetch the
+// value and stores the result in an underlying Cache. Concurrent misses for the
+// same key share a single call to the loader. A LoadingMap is safe for
+// concurrent use by multiple goroutines.
+type LoadingMap[K comparable, V any] struct {
+	cache *Cache[K, V]
+	load  func(ctx context.Context, key K) (V, error)
+
+	// refreshAhead is how long before expiry a hit triggers a background
+	// reload. It is zero if refreshing ahead is disabled.
+	refreshAhead time.Duration
+	// failures caches loader errors. It is nil if negative caching is
+	// disabled.
+	failures *Cache[K, error]
+
+	mu    sync.Mutex
+	calls map[K]*loadCall[V]
+}
+
+// loadCall is a call to the loader that is in flight or has completed.
+type loadCall[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+// LoadingOption configures a LoadingMap created by NewLoadingMap.
+type LoadingOption func(*loadingConfig)
+
+// loadingConfig collects the settings applied by LoadingOptions.
+type loadingConfig struct {
+	refreshAhead time.Duration
+	negativeTTL  time.Duration
+}
+
+// WithRefreshAhead makes a LoadingMap reload a key in the background when it
+// is read within d of its expiration. The reader is served the current value
+// without waiting, so a frequently read key never expires from the cache.
+func WithRefreshAhead(d time.Duration) LoadingOption {
+	return func(cfg *loadingConfig) {
+		cfg.refreshAhead = d
+	}
+}
+
+// WithNegativeTTL makes a LoadingMap remember loader errors for ttl. Until it
+// passes, reads of the same key return the error without calling the loader
+// again. Errors are expired using the underlying Cache's Clock.
+func WithNegativeTTL(ttl time.Duration) LoadingOption {
+	return func(cfg *loadingConfig) {
+		cfg.negativeTTL = ttl
+	}
+}
+
+// NewLoadingMap creates a LoadingMap that stores loaded values in cache and
+// fetches missing ones with load.
+func NewLoadingMap[K comparable, V any](cache *Cache[K, V], load func(ctx context.Context, key K) (V, error), opts ...LoadingOption) *LoadingMap[K, V] {
+	var cfg loadingConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	l := &LoadingMap[K, V]{
+		cache:        cache,
+		load:         load,
+		refreshAhead: cfg.refreshAhead,
+		calls:        make(map[K]*loadCall[V]),
+	}
+	if cfg.negativeTTL > 0 {
+		l.failures = NewCache[K, error](cfg.negativeTTL, WithClock(cache.clock))
+	}
+	return l
+}
+
+// Get returns the value for key, calling the loader on a miss. If the loader
+// fails, its error is returned and nothing is stored in the cache.
+//
+// The loader runs with a context that carries ctx's values but is not canceled
+// with it, because other callers may be waiting on the same load. If ctx is
+// done first, Get returns ctx.Err() and leaves the load to finish on its own.
+func (l *LoadingMap[K, V]) Get(ctx context.Context, key K) (V, error) {
+	if v, ok := l.cache.Get(key); ok {
+		if l.refreshAhead > 0 {
+			if ttl, ok := l.cache.TTL(key); ok && ttl != NoExpiration && ttl <= l.refreshAhead {
+				l.start(ctx, key, true)
+			}
+		}
+		return v, nil
+	}
+	if l.failures != nil {
+		if err, ok := l.failures.Get(key); ok {
+			var zero V
+			return zero, err
+		}
+	}
+
+	call := l.start(ctx, key, false)
+	select {
+	case <-call.done:
+		return call.value, call.err
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+// Cache returns the Cache that holds loaded values.
+func (l *LoadingMap[K, V]) Cache() *Cache[K, V] {
+	return l.cache
+}
+
+// Close releases the resources of the LoadingMap, including the underlying
+// Cache.
+func (l *LoadingMap[K, V]) Close() error {
+	if l.failures != nil {
+		l.failures.Close()
+	}
+	return l.cache.Close()
+}
+
+// start returns the in-flight load for key, beginning one if there is none.
+// A refresh stores only successful results, so a failed refresh leaves the
+// current value in place until it expires.
+func (l *LoadingMap[K, V]) start(ctx context.Context, key K, refresh bool) *loadCall[V] {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if call, ok := l.calls[key]; ok {
+		return call
+	}
+	call := &loadCall[V]{done: make(chan struct{})}
+	l.calls[key] = call
+
+	go func() {
+		call.value, call.err = l.load(context.WithoutCancel(ctx), key)
+		switch {
+		case call.err == nil:
+			l.cache.Set(key, call.value)
+		case l.failures != nil && !refresh:
+			l.failures.Set(key, call.err)
+		}
+
+		l.mu.Lock()
+		delete(l.calls, key)
+		l.mu.Unlock()
+		close(call.done)
+	}()
+	return call
+}
@@ -0,0 +1,143 @@
+package ttl
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadingMapDeduplicatesMisses(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	l := NewLoadingMap(NewMap(time.Hour), func(ctx context.Context, key string) ([]byte, error) {
+		calls.Add(1)
+		<-release
+		return []byte("loaded-" + key), nil
+	})
+	defer l.Close()
+
+	const callers = 10
+	var wg sync.WaitGroup
+	results := make(chan string, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := l.Get(context.Background(), "key")
+			if err != nil {
+				t.Errorf("Get: %v", err)
+			}
+			results <- string(v)
+		}()
+	}
+	// Give the callers a chance to pile up behind the first load.
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	for got := range results {
+		if got != "loaded-key" {
+			t.Fatalf("want %q, got %q", "loaded-key", got)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("want 1 call to the loader, got %d", n)
+	}
+	if _, ok := l.Cache().Get("key"); !ok {
+		t.Fatal("want loaded value to be cached")
+	}
+}
+
+func TestLoadingMapNegativeCaching(t *testing.T) {
+	clock := NewFakeClock(time.Date(2018, 4, 10, 0, 0, 0, 0, time.UTC))
+	errNotFound := errors.New("not found")
+	var calls int
+	l := NewLoadingMap(NewMap(time.Hour, WithClock(clock)),
+		func(ctx context.Context, key string) ([]byte, error) {
+			calls++
+			return nil, errNotFound
+		},
+		WithNegativeTTL(time.Minute),
+	)
+	defer l.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := l.Get(context.Background(), "key"); !errors.Is(err, errNotFound) {
+			t.Fatalf("want %v, got %v", errNotFound, err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("want the error to be cached after 1 call, got %d calls", calls)
+	}
+
+	clock.Advance(time.Minute)
+	l.Get(context.Background(), "key")
+	if calls != 2 {
+		t.Fatalf("want the loader to be retried once the error expires, got %d calls", calls)
+	}
+}
+
+func TestLoadingMapRefreshAhead(t *testing.T) {
+	clock := NewFakeClock(time.Date(2018, 4, 10, 0, 0, 0, 0, time.UTC))
+	var version atomic.Int32
+	refreshed := make(chan struct{}, 1)
+	l := NewLoadingMap(NewCache[string, int32](time.Minute, WithClock(clock)),
+		func(ctx context.Context, key string) (int32, error) {
+			v := version.Add(1)
+			if v > 1 {
+				refreshed <- struct{}{}
+			}
+			return v, nil
+		},
+		WithRefreshAhead(10*time.Second),
+	)
+	defer l.Close()
+
+	if v, _ := l.Get(context.Background(), "key"); v != 1 {
+		t.Fatalf("want first load to return 1, got %d", v)
+	}
+	clock.Advance(50 * time.Second)
+	if v, _ := l.Get(context.Background(), "key"); v != 1 {
+		t.Fatalf("want the current value while refreshing, got %d", v)
+	}
+
+	select {
+	case <-refreshed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("want a background refresh near expiry")
+	}
+	// The refreshed value is stored just after the loader returns.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if v, _ := l.Cache().Get("key"); v == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("want the refreshed value to be stored")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if d, _ := l.Cache().TTL("key"); d != time.Minute {
+		t.Fatalf("want refresh to reset the expiration to %v, got %v", time.Minute, d)
+	}
+}
+
+func TestLoadingMapContextCanceled(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	l := NewLoadingMap(NewMap(time.Hour), func(ctx context.Context, key string) ([]byte, error) {
+		<-release
+		return nil, nil
+	})
+	defer l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.Get(ctx, "key"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("want %v, got %v", context.Canceled, err)
+	}
+}
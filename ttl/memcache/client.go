@@ -0,0 +1,195 @@
+package memcache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client talks to a memcached text protocol server over a single connection.
+// A Client is safe for concurrent use; requests are sent one at a time.
+type Client struct {
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// Dial connects to the server at the TCP network address addr.
+func Dial(addr string) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient creates a Client that talks to a server over conn.
+func NewClient(conn net.Conn) *Client {
+	return &Client{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		w:    bufio.NewWriter(conn),
+	}
+}
+
+// Close closes the connection to the server.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Get returns the item for key, or ErrCacheMiss if there is none.
+func (c *Client) Get(key string) (*Item, error) {
+	items, err := c.GetMulti([]string{key})
+	if err != nil {
+		return nil, err
+	}
+	it, ok := items[key]
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+	return it, nil
+}
+
+// GetMulti returns the items found for keys, keyed by key. Missing keys are
+// absent from the result. The items' TTL is not reported by the protocol and
+// is left zero.
+func (c *Client) GetMulti(keys []string) (map[string]*Item, error) {
+	if len(keys) == 0 {
+		return map[string]*Item{}, nil
+	}
+	for _, key := range keys {
+		if !validKey(key) {
+			return nil, fmt.Errorf("memcache: invalid key %q", key)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.send("get " + strings.Join(keys, " ")); err != nil {
+		return nil, err
+	}
+
+	items := make(map[string]*Item)
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "END" {
+			return items, nil
+		}
+		var (
+			key   string
+			flags uint32
+			size  int
+		)
+		if _, err := fmt.Sscanf(line, "VALUE %s %d %d", &key, &flags, &size); err != nil {
+			return nil, fmt.Errorf("memcache: unexpected response %q", line)
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		items[key] = &Item{Key: key, Value: data[:size], Flags: flags}
+	}
+}
+
+// Set stores it unconditionally.
+func (c *Client) Set(it *Item) error {
+	if !validKey(it.Key) {
+		return fmt.Errorf("memcache: invalid key %q", it.Key)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(c.w, "set %s %d %d %d\r\n", it.Key, it.Flags, exptime(it.TTL), len(it.Value))
+	c.w.Write(it.Value)
+	if err := c.send(""); err != nil {
+		return err
+	}
+	return c.expect("STORED")
+}
+
+// Delete removes the item for key, or returns ErrCacheMiss if there is none.
+func (c *Client) Delete(key string) error {
+	if !validKey(key) {
+		return fmt.Errorf("memcache: invalid key %q", key)
+	}
+	return c.command("delete "+key, "DELETED")
+}
+
+// Touch resets the TTL of the item for key, or returns ErrCacheMiss if there
+// is none.
+func (c *Client) Touch(key string, ttl time.Duration) error {
+	if !validKey(key) {
+		return fmt.Errorf("memcache: invalid key %q", key)
+	}
+	return c.command("touch "+key+" "+strconv.FormatInt(exptime(ttl), 10), "TOUCHED")
+}
+
+// command sends a single-line command and checks for the success response
+// want.
+func (c *Client) command(line, want string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.send(line); err != nil {
+		return err
+	}
+	return c.expect(want)
+}
+
+// send writes line, terminated by CRLF, after anything already buffered and
+// flushes the connection. An empty line only terminates the buffered data.
+func (c *Client) send(line string) error {
+	c.w.WriteString(line + "\r\n")
+	return c.w.Flush()
+}
+
+// expect reads a response line and checks that it is want. NOT_FOUND is
+// reported as ErrCacheMiss.
+func (c *Client) expect(want string) error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	switch line {
+	case want:
+		return nil
+	case "NOT_FOUND":
+		return ErrCacheMiss
+	default:
+		return fmt.Errorf("memcache: unexpected response %q", line)
+	}
+}
+
+// readLine reads a response line without its CRLF. Error responses are
+// returned as errors.
+func (c *Client) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "ERROR" || strings.HasPrefix(line, "CLIENT_ERROR ") || strings.HasPrefix(line, "SERVER_ERROR ") {
+		return "", errors.New("memcache: " + line)
+	}
+	return line, nil
+}
+
+// exptime converts a TTL to a memcached exptime, rounding up to whole seconds
+// and switching to an absolute Unix time beyond the protocol's 30-day limit.
+func exptime(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	secs := int64((ttl + time.Second - 1) / time.Second)
+	if secs > relativeExptimeLimit {
+		return time.Now().Add(ttl).Unix()
+	}
+	return secs
+}
@@ -0,0 +1,47 @@
+// Package memcache serves a ttl.Cache over TCP using a subset of the memcached
+// text protocol, and provides a client for it. The get, set, delete and touch
+// commands are supported, along with version and quit, so existing memcached
+// tooling can talk to the server in local tests.
+package memcache
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrCacheMiss is returned by Client methods when the requested key is not
+// in the cache.
+var ErrCacheMiss = errors.New("memcache: cache miss")
+
+const (
+	// maxKeyLength is the longest key memcached accepts.
+	maxKeyLength = 250
+
+	// relativeExptimeLimit is the largest exptime memcached treats as a
+	// number of seconds from now. Larger values are absolute Unix times.
+	relativeExptimeLimit = 60 * 60 * 24 * 30
+)
+
+// Item is a value stored in the cache.
+type Item struct {
+	Key   string
+	Value []byte
+	// Flags are opaque to the server and returned unchanged with the value.
+	// Clients commonly use them to record how the value is encoded.
+	Flags uint32
+	// TTL is how long the item lives. Zero means the item never expires.
+	TTL time.Duration
+}
+
+// validKey reports whether key may be used in the text protocol.
+func validKey(key string) bool {
+	if len(key) == 0 || len(key) > maxKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
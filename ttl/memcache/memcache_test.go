@@ -0,0 +1,206 @@
+package memcache
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startServer serves a new Server on an ephemeral port until the test ends.
+func startServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	s := NewServer()
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return s, l.Addr().String()
+}
+
+func TestClient(t *testing.T) {
+	_, addr := startServer(t)
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	if _, err := c.Get("missing"); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("want %v, got %v", ErrCacheMiss, err)
+	}
+	if err := c.Set(&Item{Key: "a", Value: []byte("hello\r\nworld"), Flags: 42}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := c.Set(&Item{Key: "b", Value: []byte("2"), TTL: time.Hour}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	it, err := c.Get("a")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if string(it.Value) != "hello\r\nworld" || it.Flags != 42 {
+		t.Fatalf("want value %q with flags 42, got %q with flags %d", "hello\r\nworld", it.Value, it.Flags)
+	}
+
+	items, err := c.GetMulti([]string{"a", "b", "missing"})
+	if err != nil {
+		t.Fatalf("GetMulti: %v", err)
+	}
+	if len(items) != 2 || string(items["b"].Value) != "2" {
+		t.Fatalf("want a and b, got %v", items)
+	}
+
+	if err := c.Touch("b", time.Minute); err != nil {
+		t.Fatalf("Touch: %v", err)
+	}
+	if err := c.Touch("missing", time.Minute); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("want %v, got %v", ErrCacheMiss, err)
+	}
+	if err := c.Delete("a"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := c.Delete("a"); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("want %v, got %v", ErrCacheMiss, err)
+	}
+}
+
+// TestProtocol speaks the text protocol directly, as memcached tooling would,
+// including pipelined and noreply commands.
+func TestProtocol(t *testing.T) {
+	_, addr := startServer(t)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	defer conn.Close()
+
+	requests := "set k 7 0 3\r\nabc\r\n" +
+		"set quiet 0 0 1 noreply\r\nq\r\n" +
+		"get k quiet missing\r\n" +
+		"set expired 0 -1 1\r\nx\r\n" +
+		"get expired\r\n" +
+		"touch k 100\r\n" +
+		"delete nope\r\n" +
+		"gets k\r\n" +
+		"set big 0 0 2\r\ntoolong\r\n" +
+		"bogus\r\n" +
+		"version\r\n" +
+		"quit\r\n"
+	if _, err := conn.Write([]byte(requests)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	want := []string{
+		"STORED",
+		"VALUE k 7 3", "abc", "VALUE quiet 0 1", "q", "END",
+		"STORED",
+		"END",
+		"TOUCHED",
+		"NOT_FOUND",
+		// gets needs cas values, which are not supported.
+		"ERROR",
+		"CLIENT_ERROR bad data chunk",
+	}
+	r := bufio.NewReader(conn)
+	for _, w := range want {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading response: %v", err)
+		}
+		if got := strings.TrimRight(line, "\r\n"); got != w {
+			t.Fatalf("want %q, got %q", w, got)
+		}
+	}
+}
+
+func TestClientInvalidKey(t *testing.T) {
+	_, addr := startServer(t)
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+	if err := c.Set(&Item{Key: "victim", Value: []byte("1")}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	for _, key := range []string{"nokey\r\ndelete victim", "two words", ""} {
+		if err := c.Delete(key); err == nil || errors.Is(err, ErrCacheMiss) {
+			t.Fatalf("Delete(%q): want invalid key error, got %v", key, err)
+		}
+		if err := c.Touch(key, time.Minute); err == nil || errors.Is(err, ErrCacheMiss) {
+			t.Fatalf("Touch(%q): want invalid key error, got %v", key, err)
+		}
+	}
+	// Nothing was sent, so victim survives and the connection is in sync.
+	if _, err := c.Get("victim"); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+}
+
+func TestLineTooLong(t *testing.T) {
+	_, addr := startServer(t)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	defer conn.Close()
+
+	// A client that never ends its line must not be buffered without bound.
+	go conn.Write([]byte("get " + strings.Repeat("k", 10*maxLineLength)))
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	if want := "CLIENT_ERROR line too long\r\n"; line != want {
+		t.Fatalf("want %q, got %q", want, line)
+	}
+	if _, err := r.ReadString('\n'); err == nil {
+		t.Fatal("want connection closed")
+	}
+}
+
+func TestObjectTooLarge(t *testing.T) {
+	s, addr := startServer(t)
+	s.MaxItemSize = 4
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	err = c.Set(&Item{Key: "k", Value: []byte("12345")})
+	if err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("want object too large error, got %v", err)
+	}
+	// The rejected data must be consumed so the connection stays usable.
+	if err := c.Set(&Item{Key: "k", Value: []byte("1234")}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+}
+
+func TestLifetime(t *testing.T) {
+	if got := lifetime(0); got >= 0 {
+		t.Fatalf("want exptime 0 to never expire, got %v", got)
+	}
+	if got := lifetime(-1); got != 0 {
+		t.Fatalf("want negative exptime to expire immediately, got %v", got)
+	}
+	if got := lifetime(60); got != time.Minute {
+		t.Fatalf("want %v, got %v", time.Minute, got)
+	}
+	abs := time.Now().Add(time.Hour).Unix()
+	if got := lifetime(abs); got <= 59*time.Minute || got > time.Hour {
+		t.Fatalf("want absolute exptime about an hour away, got %v", got)
+	}
+	if got := exptime(90 * time.Millisecond); got != 1 {
+		t.Fatalf("want TTLs rounded up to whole seconds, got %d", got)
+	}
+}
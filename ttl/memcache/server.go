@@ -0,0 +1,358 @@
+package memcache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gobuildit/gobuildit/ttl"
+)
+
+// DefaultMaxItemSize is the largest value a Server accepts unless configured
+// otherwise, matching memcached's default.
+const DefaultMaxItemSize = 1024 * 1024
+
+// Version is reported in response to the version command.
+const Version = "ttl-memcache 1.0"
+
+// Server serves a ttl.Cache to memcached text protocol clients.
+type Server struct {
+	// MaxItemSize bounds the size of a value accepted by set. If zero,
+	// DefaultMaxItemSize is used.
+	MaxItemSize int
+
+	cache *ttl.Cache[string, entry]
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+// entry is the value stored in the Server's cache for each key.
+type entry struct {
+	flags uint32
+	value []byte
+}
+
+// NewServer creates a Server backed by a new ttl.Cache configured with opts.
+// Values are sized by their length for ttl.WithMaxBytes. Keys set with an
+// exptime of zero never expire.
+func NewServer(opts ...ttl.Option) *Server {
+	opts = append([]ttl.Option{
+		ttl.WithSizer(func(e entry) int64 { return int64(len(e.value)) }),
+	}, opts...)
+	return &Server{
+		cache:     ttl.NewCache[string, entry](ttl.NoExpiration, opts...),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on the TCP network address addr and then calls Serve.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l and serves each on its own goroutine. It
+// returns when l fails, or nil once the Server has been closed.
+func (s *Server) Serve(l net.Listener) error {
+	if !s.track(l, nil) {
+		l.Close()
+		return nil
+	}
+	defer s.untrack(l, nil)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return nil
+			}
+			return err
+		}
+		if !s.track(nil, conn) {
+			conn.Close()
+			return nil
+		}
+		go func() {
+			defer s.untrack(nil, conn)
+			defer conn.Close()
+			if err := s.serveConn(conn); err != nil && !s.isClosed() {
+				log.Printf("memcache: %s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// Close stops the Server's listeners, closes its connections and closes its
+// cache.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ttl.ErrClosed
+	}
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	return s.cache.Close()
+}
+
+// Stats returns the statistics of the Server's cache.
+func (s *Server) Stats() ttl.Stats {
+	return s.cache.Stats()
+}
+
+// track records a listener or connection so Close can stop it. It reports
+// false if the Server is already closed.
+func (s *Server) track(l net.Listener, c net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if l != nil {
+		s.listeners[l] = struct{}{}
+	}
+	if c != nil {
+		s.conns[c] = struct{}{}
+	}
+	return true
+}
+
+func (s *Server) untrack(l net.Listener, c net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.listeners, l)
+	delete(s.conns, c)
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// errQuit ends a connection at the client's request.
+var errQuit = errors.New("quit")
+
+// clientError is a malformed request. It is reported to the client as a
+// CLIENT_ERROR and the connection is kept open.
+type clientError string
+
+func (e clientError) Error() string { return string(e) }
+
+// maxLineLength is the longest command line accepted, as in memcached.
+const maxLineLength = 2048
+
+// serveConn reads and answers commands on conn until it is closed.
+func (s *Server) serveConn(conn net.Conn) error {
+	// The buffer must hold a line of maxLineLength bytes with room to spare,
+	// so that a longer line is noticed by ReadSlice.
+	r := bufio.NewReaderSize(conn, 2*maxLineLength)
+	w := bufio.NewWriter(conn)
+	for {
+		b, err := r.ReadSlice('\n')
+		if len(b) > maxLineLength || err == bufio.ErrBufferFull {
+			// The rest of the line cannot be skipped reliably, so give
+			// up on the connection, as memcached does.
+			w.WriteString("CLIENT_ERROR line too long\r\n")
+			return w.Flush()
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		line := string(b)
+		err = s.handle(r, w, strings.Fields(line))
+		var cerr clientError
+		switch {
+		case errors.As(err, &cerr):
+			fmt.Fprintf(w, "CLIENT_ERROR %s\r\n", cerr)
+		case err == errQuit:
+			return w.Flush()
+		case err != nil:
+			return err
+		}
+		// Only flush once pipelined commands have all been answered.
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handle executes one command, reading any data block it carries from r and
+// writing the response to w.
+func (s *Server) handle(r *bufio.Reader, w *bufio.Writer, args []string) error {
+	if len(args) == 0 {
+		w.WriteString("ERROR\r\n")
+		return nil
+	}
+	switch cmd, args := args[0], args[1:]; cmd {
+	case "get":
+		return s.get(w, args)
+	case "set":
+		return s.set(r, w, args)
+	case "delete":
+		return s.delete(w, args)
+	case "touch":
+		return s.touch(w, args)
+	case "version":
+		fmt.Fprintf(w, "VERSION %s\r\n", Version)
+		return nil
+	case "quit":
+		return errQuit
+	default:
+		w.WriteString("ERROR\r\n")
+		return nil
+	}
+}
+
+// get handles "get <key>*".
+func (s *Server) get(w *bufio.Writer, keys []string) error {
+	if len(keys) == 0 {
+		return clientError("missing key")
+	}
+	for _, key := range keys {
+		if !validKey(key) {
+			return clientError("bad key")
+		}
+	}
+	for _, key := range keys {
+		if e, ok := s.cache.Get(key); ok {
+			fmt.Fprintf(w, "VALUE %s %d %d\r\n", key, e.flags, len(e.value))
+			w.Write(e.value)
+			w.WriteString("\r\n")
+		}
+	}
+	w.WriteString("END\r\n")
+	return nil
+}
+
+// set handles "set <key> <flags> <exptime> <bytes> [noreply]" and the data
+// block that follows it.
+func (s *Server) set(r *bufio.Reader, w *bufio.Writer, args []string) error {
+	args, noreply := trimNoreply(args)
+	if len(args) != 4 {
+		return clientError("bad command line format")
+	}
+	flags, err1 := strconv.ParseUint(args[1], 10, 32)
+	exptime, err2 := strconv.ParseInt(args[2], 10, 64)
+	size, err3 := strconv.Atoi(args[3])
+	if err1 != nil || err2 != nil || err3 != nil || size < 0 {
+		return clientError("bad command line format")
+	}
+
+	// Always consume the data block, even when rejecting it, so the next
+	// command is read from the right place.
+	maxSize := s.MaxItemSize
+	if maxSize == 0 {
+		maxSize = DefaultMaxItemSize
+	}
+	if size > maxSize {
+		if _, err := r.Discard(size + 2); err != nil {
+			return err
+		}
+		w.WriteString("SERVER_ERROR object too large for cache\r\n")
+		return nil
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	if string(data[size:]) != "\r\n" {
+		return clientError("bad data chunk")
+	}
+	if !validKey(args[0]) {
+		return clientError("bad key")
+	}
+
+	s.cache.SetWithTTL(args[0], entry{flags: uint32(flags), value: data[:size]}, lifetime(exptime))
+	reply(w, noreply, "STORED")
+	return nil
+}
+
+// delete handles "delete <key> [noreply]".
+func (s *Server) delete(w *bufio.Writer, args []string) error {
+	args, noreply := trimNoreply(args)
+	if len(args) != 1 || !validKey(args[0]) {
+		return clientError("bad command line format")
+	}
+	if s.cache.Delete(args[0]) {
+		reply(w, noreply, "DELETED")
+	} else {
+		reply(w, noreply, "NOT_FOUND")
+	}
+	return nil
+}
+
+// touch handles "touch <key> <exptime> [noreply]".
+func (s *Server) touch(w *bufio.Writer, args []string) error {
+	args, noreply := trimNoreply(args)
+	if len(args) != 2 || !validKey(args[0]) {
+		return clientError("bad command line format")
+	}
+	exptime, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return clientError("invalid exptime argument")
+	}
+	if s.cache.TouchWithTTL(args[0], lifetime(exptime)) {
+		reply(w, noreply, "TOUCHED")
+	} else {
+		reply(w, noreply, "NOT_FOUND")
+	}
+	return nil
+}
+
+// lifetime converts a memcached exptime to a time to live. Zero means never,
+// values up to 30 days are seconds from now, larger values are absolute Unix
+// times, and negative values expire the item immediately.
+func lifetime(exptime int64) time.Duration {
+	switch {
+	case exptime == 0:
+		return ttl.NoExpiration
+	case exptime < 0:
+		return 0
+	case exptime <= relativeExptimeLimit:
+		return time.Duration(exptime) * time.Second
+	default:
+		return max(time.Until(time.Unix(exptime, 0)), 0)
+	}
+}
+
+// trimNoreply removes a trailing noreply argument, reporting whether it was
+// present.
+func trimNoreply(args []string) ([]string, bool) {
+	if n := len(args); n > 0 && args[n-1] == "noreply" {
+		return args[:n-1], true
+	}
+	return args, false
+}
+
+// reply writes a single-line response unless the client asked for none.
+func reply(w *bufio.Writer, noreply bool, msg string) {
+	if !noreply {
+		w.WriteString(msg + "\r\n")
+	}
+}
@@ -0,0 +1,173 @@
+package ttl
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Option configures a Cache created by NewCache or a Map created by NewMap.
+type Option func(*config)
+
+// config collects the settings applied by Options. Settings that depend on a
+// Cache's key and value types are held as empty interfaces and checked by
+// NewCache.
+type config struct {
+	clock      Clock
+	maxEntries int
+	maxBytes   int64
+	policy     EvictionPolicy
+	sizeOf     any // func(V) int64
+	equal      any // func(a, b V) bool
+	onEvict    any // func(K, V, EvictReason)
+	keyCodec   any // Codec[K]
+	valueCodec any // Codec[V]
+
+	snapshotPath     string
+	snapshotInterval time.Duration
+
+	janitor   *Janitor
+	noJanitor bool
+}
+
+// WithClock sets the Clock a Cache uses to tell time and to schedule its expiry
+// worker. By default a Cache uses the system clock.
+func WithClock(c Clock) Option {
+	return func(cfg *config) {
+		cfg.clock = c
+	}
+}
+
+// WithMaxEntries bounds the number of keys a Cache holds. When a write would
+// exceed the bound, expired keys are removed first and then keys are evicted
+// according to the Cache's EvictionPolicy, LRU by default.
+func WithMaxEntries(n int) Option {
+	return func(cfg *config) {
+		cfg.maxEntries = n
+	}
+}
+
+// WithMaxBytes bounds the total size of the values a Cache holds. Keys are
+// evicted as described for WithMaxEntries. A value larger than n is never
+// stored; setting one removes any previous value for its key.
+//
+// Byte slices and strings are sized by their length. Caches of other value
+// types must also be given WithSizer.
+func WithMaxBytes(n int64) Option {
+	return func(cfg *config) {
+		cfg.maxBytes = n
+	}
+}
+
+// WithSizer sets the function used to measure values against WithMaxBytes.
+func WithSizer[V any](fn func(V) int64) Option {
+	return func(cfg *config) {
+		cfg.sizeOf = fn
+	}
+}
+
+// WithEqual sets the function CompareAndSwap uses to compare values. It is
+// needed only for value types that cannot be compared with ==.
+func WithEqual[V any](fn func(a, b V) bool) Option {
+	return func(cfg *config) {
+		cfg.equal = fn
+	}
+}
+
+// WithEvictionPolicy sets how a size-bounded Cache chooses keys to evict. It
+// has no effect unless WithMaxEntries or WithMaxBytes is also given.
+func WithEvictionPolicy(p EvictionPolicy) Option {
+	return func(cfg *config) {
+		cfg.policy = p
+	}
+}
+
+// WithOnEvict registers fn to be called whenever a key leaves a Cache or has its
+// value replaced. fn receives the departing value and the reason it left. It
+// runs on the goroutine that caused the removal, after the Cache's lock has
+// been released, so it may call back into the Cache.
+func WithOnEvict[K comparable, V any](fn func(key K, value V, reason EvictReason)) Option {
+	return func(cfg *config) {
+		cfg.onEvict = fn
+	}
+}
+
+// typed converts a type-dependent option value to the type a Cache needs,
+// panicking with the option's name if it was given for other types.
+func typed[T any](v any, option string) T {
+	t, ok := v.(T)
+	if !ok {
+		panic(fmt.Sprintf("ttl: %s was given a %T, but the cache needs a %s", option, v, reflect.TypeFor[T]()))
+	}
+	return t
+}
+
+// defaultSizer returns the natural size function for V, or nil if V has none.
+func defaultSizer[V any]() func(V) int64 {
+	var zero V
+	switch any(zero).(type) {
+	case []byte:
+		return func(v V) int64 { return int64(len(any(v).([]byte))) }
+	case string:
+		return func(v V) int64 { return int64(len(any(v).(string))) }
+	}
+	return nil
+}
+
+// defaultEqual returns the natural equality for V, or nil if V has none.
+func defaultEqual[V any]() func(a, b V) bool {
+	var zero V
+	if _, ok := any(zero).([]byte); ok {
+		return func(a, b V) bool { return bytes.Equal(any(a).([]byte), any(b).([]byte)) }
+	}
+	if reflect.TypeFor[V]().Comparable() {
+		return func(a, b V) bool { return any(a) == any(b) }
+	}
+	return nil
+}
+
+// WithKeyCodec sets how keys are encoded in snapshots.
+func WithKeyCodec[K any](codec Codec[K]) Option {
+	return func(cfg *config) {
+		cfg.keyCodec = codec
+	}
+}
+
+// WithValueCodec sets how values are encoded in snapshots.
+func WithValueCodec[V any](codec Codec[V]) Option {
+	return func(cfg *config) {
+		cfg.valueCodec = codec
+	}
+}
+
+// WithJanitor makes a Cache rely on j to remove stale keys instead of starting
+// a worker goroutine of its own, so that many caches can share one goroutine.
+func WithJanitor(j *Janitor) Option {
+	return func(cfg *config) {
+		cfg.janitor = j
+	}
+}
+
+// WithoutJanitor makes a Cache run no goroutine at all. Stale keys are never
+// returned by reads and are removed when they are read and whenever the Cache
+// is written to.
+func WithoutJanitor() Option {
+	return func(cfg *config) {
+		cfg.noJanitor = true
+	}
+}
+
+// WithSnapshotFile keeps a Cache warm across restarts. NewCache restores the
+// Cache from the snapshot at path if one exists, the expiry worker writes a
+// fresh snapshot every interval, and Close writes a final one. An interval of
+// zero or less snapshots only on Close, as do caches created WithJanitor or
+// WithoutJanitor, which have no worker of their own. Errors restoring or
+// writing periodic snapshots are logged; an error writing the final snapshot
+// is returned by Close.
+func WithSnapshotFile(path string, interval time.Duration) Option {
+	return func(cfg *config) {
+		cfg.snapshotPath = path
+		cfg.snapshotInterval = interval
+	}
+}
@@ -0,0 +1,271 @@
+package ttl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A snapshot begins with snapshotMagic and a version byte, followed by the
+// number of entries as a uvarint. Each entry is its absolute expiration in
+// Unix nanoseconds as a varint (zero for none), then its key and its value,
+// each as a uvarint length followed by that many bytes.
+const (
+	snapshotMagic   = "TTLS"
+	snapshotVersion = 1
+
+	// maxSnapshotField bounds the length of a single encoded key or value
+	// so that a corrupt snapshot cannot force a huge allocation.
+	maxSnapshotField = 1 << 30
+)
+
+var (
+	// ErrNotSnapshot is returned by Restore when its input does not begin
+	// like a snapshot written by Snapshot.
+	ErrNotSnapshot = errors.New("ttl: not a snapshot")
+	// ErrSnapshotVersion is returned by Restore for snapshots written in a
+	// format this version of the package does not understand.
+	ErrSnapshotVersion = errors.New("ttl: unsupported snapshot version")
+)
+
+// Codec converts keys or values to and from bytes for snapshots. Strings and
+// byte slices are stored as-is by default; other types use encoding/gob unless
+// a Codec is given with WithKeyCodec or WithValueCodec.
+type Codec[T any] interface {
+	Marshal(v T) ([]byte, error)
+	Unmarshal(data []byte) (T, error)
+}
+
+// Snapshot writes every unexpired entry in the cache, with its absolute
+// expiration time, to w. The entries are copied under the read lock and
+// encoded afterwards, so a slow writer does not block the cache. Snapshot
+// returns ErrClosed once the cache has been closed.
+func (c *Cache[K, V]) Snapshot(w io.Writer) error {
+	if c.closed.Load() {
+		return ErrClosed
+	}
+	return c.snapshot(w)
+}
+
+// snapshot writes a snapshot to w whether or not the cache is closed, so that
+// Close can write the final one.
+func (c *Cache[K, V]) snapshot(w io.Writer) error {
+	now := c.clock.Now()
+	c.mu.RLock()
+	entries := make([]entry[K, V], 0, len(c.data))
+	for _, e := range c.data {
+		if !e.expired(now) {
+			entries = append(entries, entry[K, V]{key: e.key, data: e.data, expiration: e.expiration})
+		}
+	}
+	c.mu.RUnlock()
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(snapshotMagic)
+	bw.WriteByte(snapshotVersion)
+	var buf [binary.MaxVarintLen64]byte
+	bw.Write(binary.AppendUvarint(buf[:0], uint64(len(entries))))
+	for _, e := range entries {
+		var expiration int64
+		if !e.expiration.IsZero() {
+			expiration = e.expiration.UnixNano()
+		}
+		bw.Write(binary.AppendVarint(buf[:0], expiration))
+
+		key, err := c.keyCodec.Marshal(e.key)
+		if err != nil {
+			return fmt.Errorf("ttl: encoding key %v: %w", e.key, err)
+		}
+		value, err := c.valueCodec.Marshal(e.data)
+		if err != nil {
+			return fmt.Errorf("ttl: encoding value for key %v: %w", e.key, err)
+		}
+		for _, field := range [][]byte{key, value} {
+			bw.Write(binary.AppendUvarint(buf[:0], uint64(len(field))))
+			bw.Write(field)
+		}
+	}
+	return bw.Flush()
+}
+
+// Restore reads a snapshot written by Snapshot and stores its entries in the
+// cache with their original expiration times, replacing any existing values
+// for the same keys. Entries that have expired since the snapshot was taken
+// are dropped. If the snapshot cannot be read in full, the cache is left
+// unchanged. Restore returns ErrClosed once the cache has been closed.
+func (c *Cache[K, V]) Restore(r io.Reader) error {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrNotSnapshot
+		}
+		return err
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return ErrNotSnapshot
+	}
+	if v := header[len(snapshotMagic)]; v != snapshotVersion {
+		return fmt.Errorf("%w %d", ErrSnapshotVersion, v)
+	}
+
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return fmt.Errorf("ttl: reading snapshot: %w", unexpected(err))
+	}
+	var entries []entry[K, V]
+	for i := uint64(0); i < n; i++ {
+		e, err := c.readEntry(br)
+		if err != nil {
+			return fmt.Errorf("ttl: reading snapshot entry %d: %w", i, unexpected(err))
+		}
+		entries = append(entries, e)
+	}
+
+	now := c.clock.Now()
+	c.mu.Lock()
+	defer c.unlock()
+	if c.closed.Load() {
+		return ErrClosed
+	}
+	for _, e := range entries {
+		if !e.expired(now) {
+			c.set(now, e.key, e.data, e.expiration)
+		}
+	}
+	return nil
+}
+
+// readEntry decodes one snapshot entry from r.
+func (c *Cache[K, V]) readEntry(r *bufio.Reader) (entry[K, V], error) {
+	var e entry[K, V]
+	expiration, err := binary.ReadVarint(r)
+	if err != nil {
+		return e, err
+	}
+	if expiration != 0 {
+		e.expiration = time.Unix(0, expiration)
+	}
+	key, err := readField(r)
+	if err != nil {
+		return e, err
+	}
+	value, err := readField(r)
+	if err != nil {
+		return e, err
+	}
+	if e.key, err = c.keyCodec.Unmarshal(key); err != nil {
+		return e, fmt.Errorf("decoding key: %w", err)
+	}
+	if e.data, err = c.valueCodec.Unmarshal(value); err != nil {
+		return e, fmt.Errorf("decoding value: %w", err)
+	}
+	return e, nil
+}
+
+// readField reads a uvarint length and then that many bytes from r.
+func readField(r *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxSnapshotField {
+		return nil, fmt.Errorf("field of %d bytes exceeds limit", n)
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	return b, err
+}
+
+// unexpected converts io.EOF, which is only expected before a snapshot has
+// begun, to io.ErrUnexpectedEOF.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// restoreFile restores the cache from the snapshot at path. A missing file is
+// not an error, since it simply means no snapshot has been taken yet.
+func (c *Cache[K, V]) restoreFile(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.Restore(f)
+}
+
+// snapshotFile writes a snapshot to path. The snapshot is written to a
+// temporary file in the same directory and renamed into place, so a crash
+// never leaves a partial snapshot at path.
+func (c *Cache[K, V]) snapshotFile(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // a no-op once the file has been renamed
+
+	if err := c.snapshot(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// defaultCodec returns the Codec used for T when none is configured.
+func defaultCodec[T any]() Codec[T] {
+	var zero T
+	switch any(zero).(type) {
+	case string:
+		return any(stringCodec{}).(Codec[T])
+	case []byte:
+		return any(bytesCodec{}).(Codec[T])
+	}
+	return gobCodec[T]{}
+}
+
+// stringCodec stores strings as their bytes.
+type stringCodec struct{}
+
+func (stringCodec) Marshal(v string) ([]byte, error)      { return []byte(v), nil }
+func (stringCodec) Unmarshal(data []byte) (string, error) { return string(data), nil }
+
+// bytesCodec stores byte slices unchanged.
+type bytesCodec struct{}
+
+func (bytesCodec) Marshal(v []byte) ([]byte, error)      { return v, nil }
+func (bytesCodec) Unmarshal(data []byte) ([]byte, error) { return data, nil }
+
+// gobCodec stores values of any type gob can encode.
+type gobCodec[T any] struct{}
+
+func (gobCodec[T]) Marshal(v T) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec[T]) Unmarshal(data []byte) (T, error) {
+	var v T
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v)
+	return v, err
+}
@@ -0,0 +1,145 @@
+package ttl
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	clock := NewFakeClock(time.Date(2018, 4, 10, 0, 0, 0, 0, time.UTC))
+	src := NewMap(time.Hour, WithClock(clock))
+	defer src.Close()
+	src.Set("a", []byte("1"))
+	src.SetWithTTL("b", []byte("2"), time.Minute)
+	src.SetWithTTL("c", []byte("3"), NoExpiration)
+
+	var buf bytes.Buffer
+	if err := src.Snapshot(&buf); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	// Restore a minute later, by which time b has expired.
+	clock.Advance(time.Minute)
+	dst := NewMap(time.Second, WithClock(clock))
+	defer dst.Close()
+	if err := dst.Restore(&buf); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	if d, ok := dst.TTL("a"); !ok || d != 59*time.Minute {
+		t.Fatalf("want a to keep its absolute expiration, got %v (present=%v)", d, ok)
+	}
+	if _, ok := dst.Get("b"); ok {
+		t.Fatal("want expired b to be dropped on restore")
+	}
+	if d, ok := dst.TTL("c"); !ok || d != NoExpiration {
+		t.Fatalf("want c to never expire, got %v (present=%v)", d, ok)
+	}
+	if got := dst.Len(); got != 2 {
+		t.Fatalf("want 2 keys restored, got %d", got)
+	}
+}
+
+func TestSnapshotGob(t *testing.T) {
+	type point struct{ X, Y int }
+	src := NewCache[point, []string](time.Hour)
+	defer src.Close()
+	src.Set(point{1, 2}, []string{"a", "b"})
+
+	var buf bytes.Buffer
+	if err := src.Snapshot(&buf); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	dst := NewCache[point, []string](time.Hour)
+	defer dst.Close()
+	if err := dst.Restore(&buf); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if got, ok := dst.Get(point{1, 2}); !ok || len(got) != 2 || got[1] != "b" {
+		t.Fatalf("want [a b], got %v (present=%v)", got, ok)
+	}
+}
+
+func TestRestoreErrors(t *testing.T) {
+	src := NewMap(time.Hour)
+	defer src.Close()
+	src.Set("a", []byte("1"))
+	var buf bytes.Buffer
+	if err := src.Snapshot(&buf); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	valid := buf.Bytes()
+
+	badVersion := append([]byte(nil), valid...)
+	badVersion[len(snapshotMagic)] = snapshotVersion + 1
+
+	tests := []struct {
+		name  string
+		input []byte
+		want  error
+	}{
+		{"empty", nil, ErrNotSnapshot},
+		{"magic", []byte("not a snapshot"), ErrNotSnapshot},
+		{"version", badVersion, ErrSnapshotVersion},
+		{"truncated", valid[:len(valid)-1], io.ErrUnexpectedEOF},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dst := NewMap(time.Hour)
+			defer dst.Close()
+			err := dst.Restore(bytes.NewReader(tc.input))
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("want %v, got %v", tc.want, err)
+			}
+			if dst.Len() != 0 {
+				t.Fatal("want a failed restore to leave the cache unchanged")
+			}
+		})
+	}
+}
+
+func TestSnapshotFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snap")
+
+	m := NewMap(time.Hour, WithSnapshotFile(path, 0))
+	m.Set("key", []byte("value"))
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	warm := NewMap(time.Hour, WithSnapshotFile(path, 0))
+	defer warm.Close()
+	if got, ok := warm.Get("key"); !ok || string(got) != "value" {
+		t.Fatalf("want restarted map to be warm, got %q (present=%v)", got, ok)
+	}
+}
+
+func TestPeriodicSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snap")
+	clock := NewFakeClock(time.Date(2018, 4, 10, 0, 0, 0, 0, time.UTC))
+	m := NewMap(time.Hour, WithClock(clock), WithSnapshotFile(path, time.Minute))
+	defer m.Close()
+	m.Set("key", []byte("value"))
+
+	clock.Advance(time.Minute)
+
+	// The worker writes the snapshot asynchronously after the tick.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		check := NewMap(time.Hour, WithClock(clock))
+		err := check.restoreFile(path)
+		n := check.Len()
+		check.Close()
+		if err == nil && n == 1 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want periodic snapshot with 1 key, got %d keys (err=%v)", n, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,120 @@
+package ttl
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"weak"
+)
+
+// Stats reports how a Cache has been used since it was created.
+type Stats struct {
+	// Hits and Misses count reads that did and did not find a value.
+	Hits   uint64
+	Misses uint64
+	// Sets counts values written, including those stored by GetOrSet and
+	// CompareAndSwap.
+	Sets uint64
+	// Expirations counts keys removed because their expiration passed.
+	Expirations uint64
+	// Evictions counts keys removed to keep a size-bounded cache within its
+	// bounds.
+	Evictions uint64
+	// Entries and Bytes describe the cache's current contents. Bytes is
+	// measured as for WithMaxBytes and is zero for value types without a
+	// known size.
+	Entries int
+	Bytes   int64
+}
+
+// counters holds the running totals behind Stats.
+type counters struct {
+	hits, misses, sets, expirations, evictions atomic.Uint64
+}
+
+// removed counts a key leaving the cache for the given reason.
+func (c *counters) removed(reason EvictReason) {
+	switch reason {
+	case Expired:
+		c.expirations.Add(1)
+	case Capacity:
+		c.evictions.Add(1)
+	}
+}
+
+// Stats returns the cache's counters and current size. Entries and Bytes may
+// include expired keys that have not yet been removed.
+func (c *Cache[K, V]) Stats() Stats {
+	c.mu.RLock()
+	entries, bytes := len(c.data), c.bytes
+	c.mu.RUnlock()
+	return Stats{
+		Hits:        c.stats.hits.Load(),
+		Misses:      c.stats.misses.Load(),
+		Sets:        c.stats.sets.Load(),
+		Expirations: c.stats.expirations.Load(),
+		Evictions:   c.stats.evictions.Load(),
+		Entries:     entries,
+		Bytes:       bytes,
+	}
+}
+
+// Publish exports the cache's Stats as an expvar variable with the given name,
+// so they appear at /debug/vars alongside the process's other variables. Like
+// expvar.Publish, it panics if the name is already in use.
+//
+// Since expvar variables cannot be removed, the variable holds the cache
+// weakly, so that publishing it does not keep it alive. Once the cache has
+// been collected, the variable reports null.
+func (c *Cache[K, V]) Publish(name string) {
+	wp := weak.Make(c)
+	expvar.Publish(name, expvar.Func(func() any {
+		c := wp.Value()
+		if c == nil {
+			return nil
+		}
+		return c.Stats()
+	}))
+}
+
+// labelEscaper escapes a label value for the Prometheus text format, which
+// allows only backslash, double quote and newline to be escaped.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WritePrometheus writes the Stats of each named cache to w in the Prometheus
+// text exposition format. Each cache's metrics carry a cache label with its
+// name.
+func WritePrometheus(w io.Writer, caches map[string]Stats) error {
+	names := make([]string, 0, len(caches))
+	for name := range caches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	metrics := []struct {
+		name, kind, help string
+		value            func(Stats) any
+	}{
+		{"ttl_cache_hits_total", "counter", "Reads that found a value.", func(s Stats) any { return s.Hits }},
+		{"ttl_cache_misses_total", "counter", "Reads that found no value.", func(s Stats) any { return s.Misses }},
+		{"ttl_cache_sets_total", "counter", "Values written.", func(s Stats) any { return s.Sets }},
+		{"ttl_cache_expirations_total", "counter", "Keys removed on expiry.", func(s Stats) any { return s.Expirations }},
+		{"ttl_cache_evictions_total", "counter", "Keys evicted for capacity.", func(s Stats) any { return s.Evictions }},
+		{"ttl_cache_entries", "gauge", "Keys currently stored.", func(s Stats) any { return s.Entries }},
+		{"ttl_cache_bytes", "gauge", "Size of the values currently stored.", func(s Stats) any { return s.Bytes }},
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		fmt.Fprintf(bw, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(bw, "# TYPE %s %s\n", m.name, m.kind)
+		for _, name := range names {
+			fmt.Fprintf(bw, "%s{cache=\"%s\"} %v\n", m.name, labelEscaper.Replace(name), m.value(caches[name]))
+		}
+	}
+	return bw.Flush()
+}
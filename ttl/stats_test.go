@@ -0,0 +1,100 @@
+package ttl
+
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	clock := NewFakeClock(time.Date(2018, 4, 10, 0, 0, 0, 0, time.UTC))
+	m := NewMap(time.Minute, WithClock(clock), WithMaxEntries(2))
+	defer m.Close()
+
+	m.Set("a", []byte("1"))
+	m.Set("b", []byte("22"))
+	m.Get("a")
+	m.Get("missing")
+	m.Set("c", []byte("333")) // evicts b
+	clock.Advance(time.Minute)
+	m.Get("a") // expired
+
+	want := Stats{
+		Hits:        1,
+		Misses:      2,
+		Sets:        3,
+		Expirations: 1,
+		Evictions:   1,
+		Entries:     1,
+		Bytes:       3,
+	}
+	if got := m.Stats(); got != want {
+		t.Fatalf("want %+v, got %+v", want, got)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	m := NewMap(time.Hour)
+	defer m.Close()
+	m.Set("a", []byte("1"))
+	// expvar names are process-wide, so use a fresh one on every run.
+	name := fmt.Sprintf("ttl-test-publish-%d", time.Now().UnixNano())
+	m.Publish(name)
+
+	got := expvar.Get(name).String()
+	if !strings.Contains(got, `"Sets":1`) || !strings.Contains(got, `"Entries":1`) {
+		t.Fatalf("want published stats, got %s", got)
+	}
+}
+
+func TestPublishDoesNotPin(t *testing.T) {
+	name := fmt.Sprintf("ttl-test-publish-pin-%d", time.Now().UnixNano())
+	NewMap(time.Hour).Publish(name)
+
+	// The first collection runs the cache's finalizer and the next frees it.
+	for i := 0; i < 10; i++ {
+		runtime.GC()
+		if got := expvar.Get(name).String(); got == "null" {
+			return
+		}
+	}
+	t.Fatalf("want published cache to be collected, got %s", expvar.Get(name).String())
+}
+
+func TestWritePrometheus(t *testing.T) {
+	var buf bytes.Buffer
+	err := WritePrometheus(&buf, map[string]Stats{
+		"sessions": {Hits: 3, Entries: 2},
+		"config":   {Hits: 1},
+	})
+	if err != nil {
+		t.Fatalf("WritePrometheus: %v", err)
+	}
+
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE ttl_cache_hits_total counter\n" +
+			"ttl_cache_hits_total{cache=\"config\"} 1\n" +
+			"ttl_cache_hits_total{cache=\"sessions\"} 3\n",
+		"# TYPE ttl_cache_entries gauge\n",
+		"ttl_cache_entries{cache=\"sessions\"} 2\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("want output to contain %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestWritePrometheusEscapesLabels(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WritePrometheus(&buf, map[string]Stats{"a\\b\"c\nd\te": {Hits: 1}}); err != nil {
+		t.Fatalf("WritePrometheus: %v", err)
+	}
+	if want := "ttl_cache_hits_total{cache=\"a\\\\b\\\"c\\nd\te\"} 1\n"; !strings.Contains(buf.String(), want) {
+		t.Fatalf("want output to contain %q, got:\n%s", want, buf.String())
+	}
+}
@@ -1,67 +1,675 @@
-package main
+// Package ttl provides an in-memory cache whose keys expire after a time to
+// live. Expiry is driven by a worker goroutine that is started in the
+// constructor and stopped by Close; see cmd/soak for what happens when Close is
+// forgotten.
+package ttl
 
-import "time"
+import (
+	"container/heap"
+	"container/list"
+	"fmt"
+	"log"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+	"weak"
+)
+
+// Cache provides an expiring storage for keys and values of any type. A Cache
+// is safe for concurrent use by multiple goroutines.
+type Cache[K comparable, V any] struct {
+	// mu guards data and expiries. Readers share the lock, so concurrent
+	// calls to Get do not serialize behind one another; only writers and the
+	// expiry worker take the lock exclusively.
+	mu       sync.RWMutex
+	data     map[K]*entry[K, V]
+	expiries expiryHeap[K, V]
+
+	// maxEntries and maxBytes bound the size of the cache when positive.
+	// bytes is the total size of all stored values as reported by sizeOf and
+	// is guarded by mu.
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+	sizeOf     func(V) int64
+	// evictor is nil unless the cache is size-bounded. Writers call it while
+	// holding mu for writing; readers hold mu for reading and also take
+	// evictMu, so recording an access serializes readers of a bounded cache.
+	evictor evictor[K, V]
+	evictMu sync.Mutex
+
+	// onEvict, if set, is told about every key that leaves the cache.
+	// Removals are queued in evicted while mu is held and delivered by
+	// unlock, so the callback may safely call back into the cache.
+	onEvict func(key K, value V, reason EvictReason)
+	evicted []eviction[K, V]
+
+	// equal compares values for CompareAndSwap. It is nil if V has no
+	// natural equality and none was configured.
+	equal func(a, b V) bool
+
+	// keyCodec and valueCodec encode entries for snapshots. If snapshotPath
+	// is set, the cache is snapshotted there periodically and on Close.
+	keyCodec         Codec[K]
+	valueCodec       Codec[V]
+	snapshotPath     string
+	snapshotInterval time.Duration
+
+	// stats counts operations for Stats. The counters are updated
+	// atomically, since hits and misses are recorded under the read lock.
+	stats counters
 
-// Map provides an expiring storage for keys and values.
-type Map struct {
-	data       map[string]expiringValue
 	expiration time.Duration
+	clock      Clock
+
+	// lazy is set when no worker or Janitor expires keys, either because
+	// the cache was created WithoutJanitor or because it has been closed,
+	// in which case writes remove expired keys instead.
+	lazy bool
+	// done is closed by Close to stop the worker. unregister, if set,
+	// removes the cache from its Janitor.
 	done       chan struct{}
+	closed     atomic.Bool
+	unregister func()
 }
 
-// expiringValue associates on piece of data with an expiration.
-type expiringValue struct {
+// Map is a Cache of byte slices keyed by strings. Its size in bytes is the
+// total length of the stored values.
+type Map = Cache[string, []byte]
+
+// NoExpiration may be passed to NewCache or SetWithTTL to store values that
+// never expire.
+const NoExpiration time.Duration = -1
+
+// defaultSweepInterval is how often stale keys are removed when the Cache's
+// default expiration does not provide an interval of its own.
+const defaultSweepInterval = time.Minute
+
+// entry associates one piece of data with an expiration. A zero expiration
+// means the value never expires.
+type entry[K comparable, V any] struct {
+	key        K
+	data       V
 	expiration time.Time
-	data       []byte
+	// index is the entry's position in the expiry heap, or -1 if the entry
+	// never expires.
+	index int
+
+	// elem is the entry's element in the LRU evictor's list.
+	elem *list.Element
+	// uses, lastUse and lfuIndex are maintained by the LFU evictor.
+	uses, lastUse uint64
+	lfuIndex      int
+}
+
+// expired reports whether e is stale at time now. An entry expires the moment
+// its full lifetime has elapsed.
+func (e *entry[K, V]) expired(now time.Time) bool {
+	return !e.expiration.IsZero() && !now.Before(e.expiration)
 }
 
 // NewMap creates a Map type and starts a worker goroutine to expire stale keys.
-func NewMap(expiration time.Duration) *Map {
-	m := &Map{
-		data:       make(map[string]expiringValue),
+// The expiration is applied to every key stored with Set. An expiration of
+// NoExpiration keeps such keys until they are overwritten.
+func NewMap(expiration time.Duration, opts ...Option) *Map {
+	return NewCache[string, []byte](expiration, opts...)
+}
+
+// NewCache creates a Cache and starts a worker goroutine to expire stale keys,
+// unless WithJanitor or WithoutJanitor is given. The expiration is applied to
+// every key stored with Set. An expiration of NoExpiration keeps such keys
+// until they are overwritten.
+//
+// NewCache panics if an option does not suit the Cache's key and value types,
+// such as a WithOnEvict callback of the wrong type, or WithMaxBytes for a value
+// type whose size is unknown and for which no WithSizer was given.
+func NewCache[K comparable, V any](expiration time.Duration, opts ...Option) *Cache[K, V] {
+	cfg := config{clock: realClock{}}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	c := &Cache[K, V]{
+		data:       make(map[K]*entry[K, V]),
+		maxEntries: cfg.maxEntries,
+		maxBytes:   cfg.maxBytes,
+		sizeOf:     defaultSizer[V](),
+		equal:      defaultEqual[V](),
+		keyCodec:   defaultCodec[K](),
+		valueCodec: defaultCodec[V](),
 		expiration: expiration,
+		clock:      cfg.clock,
 		done:       make(chan struct{}),
+
+		lazy:             cfg.noJanitor && cfg.janitor == nil,
+		snapshotPath:     cfg.snapshotPath,
+		snapshotInterval: cfg.snapshotInterval,
+	}
+	if cfg.sizeOf != nil {
+		c.sizeOf = typed[func(V) int64](cfg.sizeOf, "WithSizer")
+	}
+	if cfg.equal != nil {
+		c.equal = typed[func(a, b V) bool](cfg.equal, "WithEqual")
+	}
+	if cfg.onEvict != nil {
+		c.onEvict = typed[func(K, V, EvictReason)](cfg.onEvict, "WithOnEvict")
+	}
+	if cfg.keyCodec != nil {
+		c.keyCodec = typed[Codec[K]](cfg.keyCodec, "WithKeyCodec")
+	}
+	if cfg.valueCodec != nil {
+		c.valueCodec = typed[Codec[V]](cfg.valueCodec, "WithValueCodec")
+	}
+	if c.sizeOf == nil {
+		if c.maxBytes > 0 {
+			var zero V
+			panic(fmt.Sprintf("ttl: WithMaxBytes needs WithSizer for values of type %T", zero))
+		}
+		c.sizeOf = func(V) int64 { return 0 }
+	}
+	if c.maxEntries > 0 || c.maxBytes > 0 {
+		c.evictor = newEvictor[K, V](cfg.policy)
+	}
+
+	if c.snapshotPath != "" {
+		if err := c.restoreFile(c.snapshotPath); err != nil {
+			log.Printf("ttl: restoring snapshot %s: %v", c.snapshotPath, err)
+		}
+	}
+
+	switch {
+	case cfg.janitor != nil:
+		c.unregister = cfg.janitor.add(sweeper(c))
+	case !cfg.noJanitor:
+		interval := expiration
+		if interval <= 0 {
+			interval = defaultSweepInterval
+		}
+		c.startWorker(interval)
+	}
+
+	// If the cache is dropped without being closed, close it anyway so its
+	// worker does not leak. The worker holds only a weak pointer to the
+	// cache, so it does not keep the cache reachable.
+	runtime.SetFinalizer(c, (*Cache[K, V]).Close)
+
+	return c
+}
+
+// startWorker starts the goroutine that expires stale keys every interval and
+// writes periodic snapshots. It exits when the cache is closed or is no longer
+// reachable.
+func (c *Cache[K, V]) startWorker(interval time.Duration) {
+	// Create the tickers before starting the worker so that a fake clock
+	// advanced immediately after NewCache returns still fires them.
+	ticker := c.clock.NewTicker(interval)
+	var (
+		snapshotTicker Ticker
+		snapshots      <-chan time.Time
+	)
+	if c.snapshotPath != "" && c.snapshotInterval > 0 {
+		snapshotTicker = c.clock.NewTicker(c.snapshotInterval)
+		snapshots = snapshotTicker.C()
 	}
 
+	wp, done := weak.Make(c), c.done
 	go func() {
-		ticker := time.NewTicker(expiration)
 		defer ticker.Stop()
+		if snapshotTicker != nil {
+			defer snapshotTicker.Stop()
+		}
 		for {
 			select {
-			case <-ticker.C:
-				m.removeExpired()
-			case <-m.done:
+			case <-ticker.C():
+				c := wp.Value()
+				if c == nil {
+					return
+				}
+				c.removeExpired()
+			case <-snapshots:
+				c := wp.Value()
+				if c == nil {
+					return
+				}
+				if err := c.snapshotFile(c.snapshotPath); err != nil {
+					log.Printf("ttl: writing snapshot %s: %v", c.snapshotPath, err)
+				}
+			case <-done:
 				return
 			}
 		}
 	}()
+}
 
-	return m
+// Get retrieves a particular key from the cache. Keys whose expiration has
+// passed are reported as missing and removed, even if the expiry worker has
+// not yet run.
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	var zero V
+	now := c.clock.Now()
+	c.mu.RLock()
+	e, ok := c.data[key]
+	if !ok {
+		c.mu.RUnlock()
+		c.stats.misses.Add(1)
+		return zero, false
+	}
+	if !e.expired(now) {
+		data := e.data
+		c.stats.hits.Add(1)
+		if c.evictor != nil {
+			c.evictMu.Lock()
+			c.evictor.access(e)
+			c.evictMu.Unlock()
+		}
+		c.mu.RUnlock()
+		return data, true
+	}
+	c.mu.RUnlock()
+	c.stats.misses.Add(1)
+
+	// The entry is stale. Upgrade to a write lock to evict it, checking that
+	// it was not replaced while no lock was held.
+	c.mu.Lock()
+	defer c.unlock()
+	if cur, ok := c.data[key]; ok && cur == e && e.expired(now) {
+		c.remove(e, Expired)
+	}
+	return zero, false
 }
 
-// Get retrieves a particular key from the map.
-func (m *Map) Get(key string) ([]byte, bool) {
-	v, ok := m.data[key]
-	return v.data, ok
+// TTL reports how much longer key will remain in the cache. Keys stored without
+// an expiration report NoExpiration. The boolean is false if key is not present.
+func (c *Cache[K, V]) TTL(key K) (time.Duration, bool) {
+	now := c.clock.Now()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.data[key]
+	if !ok || e.expired(now) {
+		return 0, false
+	}
+	if e.expiration.IsZero() {
+		return NoExpiration, true
+	}
+	return e.expiration.Sub(now), true
 }
 
 // Set records a key and value with the configuration expiration.
-func (m *Map) Set(key string, value []byte) {
-	expiration := time.Now().Add(m.expiration)
-	m.data[key] = expiringValue{data: value, expiration: expiration}
+func (c *Cache[K, V]) Set(key K, value V) {
+	c.SetWithTTL(key, value, c.expiration)
+}
+
+// SetWithTTL records a key and value that expires after ttl, overriding the
+// configured expiration. A ttl of NoExpiration stores the value until it is
+// overwritten.
+func (c *Cache[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	now := c.clock.Now()
+	c.mu.Lock()
+	defer c.unlock()
+	c.set(now, key, value, deadline(now, ttl))
+}
+
+// Store is like SetWithTTL but returns ErrClosed, storing nothing, once the
+// cache has been closed. Callers that rely on a cache created WithSnapshotFile
+// can use it to learn of writes that would be missing from the final snapshot.
+func (c *Cache[K, V]) Store(key K, value V, ttl time.Duration) error {
+	now := c.clock.Now()
+	c.mu.Lock()
+	defer c.unlock()
+	// Close marks the cache closed before taking mu to write its snapshot,
+	// so a write that gets past this check is included in the snapshot.
+	if c.closed.Load() {
+		return ErrClosed
+	}
+	c.set(now, key, value, deadline(now, ttl))
+	return nil
+}
+
+// GetOrSet returns the existing value for key if present. Otherwise, it stores
+// value with the configured expiration and returns it. The loaded result is
+// true if the value was loaded, false if stored.
+func (c *Cache[K, V]) GetOrSet(key K, value V) (actual V, loaded bool) {
+	now := c.clock.Now()
+	c.mu.Lock()
+	defer c.unlock()
+	if e, ok := c.lookup(now, key); ok {
+		c.stats.hits.Add(1)
+		if c.evictor != nil {
+			c.evictor.access(e)
+		}
+		return e.data, true
+	}
+	c.stats.misses.Add(1)
+	c.set(now, key, value, deadline(now, c.expiration))
+	return value, false
+}
+
+// CompareAndSwap stores new for key with the configured expiration if the
+// key's current value is equal to old. It reports whether the swap happened.
+// Values are compared with bytes.Equal for byte slices, with == for other
+// comparable types, and otherwise with the function given to WithEqual;
+// CompareAndSwap panics if there is no way to compare values.
+func (c *Cache[K, V]) CompareAndSwap(key K, old, new V) bool {
+	now := c.clock.Now()
+	if c.equal == nil {
+		var zero V
+		panic(fmt.Sprintf("ttl: CompareAndSwap needs WithEqual for values of type %T", zero))
+	}
+	c.mu.Lock()
+	defer c.unlock()
+	e, ok := c.lookup(now, key)
+	if !ok || !c.equal(e.data, old) {
+		return false
+	}
+	c.set(now, key, new, deadline(now, c.expiration))
+	return true
+}
+
+// Touch resets the expiration of key to the configured expiration without
+// changing its value. It reports whether key was present.
+func (c *Cache[K, V]) Touch(key K) bool {
+	return c.TouchWithTTL(key, c.expiration)
+}
+
+// TouchWithTTL resets key to expire after ttl without changing its value. It
+// reports whether key was present.
+func (c *Cache[K, V]) TouchWithTTL(key K, ttl time.Duration) bool {
+	now := c.clock.Now()
+	c.mu.Lock()
+	defer c.unlock()
+	e, ok := c.lookup(now, key)
+	if !ok {
+		return false
+	}
+	c.schedule(e, deadline(now, ttl))
+	return true
+}
+
+// Delete removes key from the cache and reports whether it was present.
+func (c *Cache[K, V]) Delete(key K) bool {
+	now := c.clock.Now()
+	c.mu.Lock()
+	defer c.unlock()
+	e, ok := c.lookup(now, key)
+	if !ok {
+		return false
+	}
+	c.remove(e, Deleted)
+	return true
+}
+
+// Clear removes every key from the cache.
+func (c *Cache[K, V]) Clear() {
+	now := c.clock.Now()
+	c.mu.Lock()
+	defer c.unlock()
+	c.removeExpiredLocked(now)
+	for _, e := range c.data {
+		c.remove(e, Deleted)
+	}
 }
 
-// removeExpired removes any stale keys.
-func (m *Map) removeExpired() {
-	for k, v := range m.data {
-		if time.Now().After(v.expiration) {
-			delete(m.data, k)
+// Len returns the number of keys in the cache. Expired keys are removed before
+// counting.
+func (c *Cache[K, V]) Len() int {
+	now := c.clock.Now()
+	c.mu.Lock()
+	defer c.unlock()
+	c.removeExpiredLocked(now)
+	return len(c.data)
+}
+
+// Keys returns the keys in the cache in no particular order.
+func (c *Cache[K, V]) Keys() []K {
+	now := c.clock.Now()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	keys := make([]K, 0, len(c.data))
+	for k, e := range c.data {
+		if !e.expired(now) {
+			keys = append(keys, k)
 		}
 	}
+	return keys
+}
+
+// Range calls fn for each key and value in the cache, stopping early if fn
+// returns false. Range iterates over a snapshot taken when it is called, so
+// fn may call other methods on the cache. Iteration does not count as use for
+// a size-bounded cache's eviction policy.
+func (c *Cache[K, V]) Range(fn func(key K, value V) bool) {
+	now := c.clock.Now()
+	c.mu.RLock()
+	snapshot := make([]*entry[K, V], 0, len(c.data))
+	for _, e := range c.data {
+		if !e.expired(now) {
+			snapshot = append(snapshot, &entry[K, V]{key: e.key, data: e.data})
+		}
+	}
+	c.mu.RUnlock()
+
+	for _, e := range snapshot {
+		if !fn(e.key, e.data) {
+			return
+		}
+	}
+}
+
+// deadline returns when a value stored at now with the given ttl expires, or
+// the zero time for NoExpiration.
+func deadline(now time.Time, ttl time.Duration) time.Time {
+	if ttl == NoExpiration {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
+
+// lookup returns the entry for key if it is present and not expired. An
+// expired entry is removed. The caller must hold mu for writing.
+func (c *Cache[K, V]) lookup(now time.Time, key K) (*entry[K, V], bool) {
+	e, ok := c.data[key]
+	if !ok {
+		return nil, false
+	}
+	if e.expired(now) {
+		c.remove(e, Expired)
+		return nil, false
+	}
+	return e, true
+}
+
+// set stores value under key to expire at expiration, or never if expiration
+// is zero. The caller must hold mu for writing.
+func (c *Cache[K, V]) set(now time.Time, key K, value V, expiration time.Time) {
+	c.stats.sets.Add(1)
+	if c.lazy {
+		c.removeExpiredLocked(now)
+	}
+	e, ok := c.lookup(now, key)
+	if c.evictor != nil {
+		if ok {
+			c.evictor.access(e)
+		}
+		size := c.sizeOf(value)
+		if c.maxBytes > 0 && size > c.maxBytes {
+			// The value could never fit, so drop the key altogether
+			// rather than keeping a stale value.
+			if ok {
+				c.remove(e, Capacity)
+			}
+			return
+		}
+		c.makeRoom(now, key, size)
+		e, ok = c.data[key]
+	}
+	if ok {
+		c.bytes -= c.sizeOf(e.data)
+		c.notify(e, Replaced)
+	} else {
+		e = &entry[K, V]{key: key, index: -1}
+		c.data[key] = e
+		if c.evictor != nil {
+			c.evictor.add(e)
+		}
+	}
+	e.data = value
+	c.bytes += c.sizeOf(value)
+	c.schedule(e, expiration)
+}
+
+// schedule moves e to its place in the expiry heap for the new expiration.
+// The caller must hold mu for writing.
+func (c *Cache[K, V]) schedule(e *entry[K, V], expiration time.Time) {
+	e.expiration = expiration
+	switch {
+	case expiration.IsZero() && e.index >= 0:
+		heap.Remove(&c.expiries, e.index)
+	case expiration.IsZero():
+		// Never expires and is not scheduled; nothing to do.
+	case e.index >= 0:
+		heap.Fix(&c.expiries, e.index)
+	default:
+		heap.Push(&c.expiries, e)
+	}
 }
 
-// Close shoudl be called after the Map will no longer be used.
-func (m *Map) Close() error {
-	close(m.done)
+// removeExpired removes any stale keys. Entries are popped from the expiry
+// heap in deadline order, so the work done is proportional to the number of
+// keys that have actually expired rather than the size of the cache.
+func (c *Cache[K, V]) removeExpired() {
+	now := c.clock.Now()
+	c.mu.Lock()
+	defer c.unlock()
+	c.removeExpiredLocked(now)
+}
+
+// removeExpiredLocked removes keys that are stale at time now. The caller must
+// hold mu for writing.
+func (c *Cache[K, V]) removeExpiredLocked(now time.Time) {
+	for len(c.expiries) > 0 && c.expiries[0].expired(now) {
+		c.remove(c.expiries[0], Expired)
+	}
+}
+
+// makeRoom ensures a value of n bytes can be stored under key without taking
+// a size-bounded cache over capacity, first by removing expired keys and then by
+// evicting the keys chosen by the evictor. The victim may be key itself, in
+// which case it is stored afresh. The caller must hold mu for writing.
+func (c *Cache[K, V]) makeRoom(now time.Time, key K, n int64) {
+	if !c.overflows(key, n) {
+		return
+	}
+	c.removeExpiredLocked(now)
+	for c.overflows(key, n) {
+		c.remove(c.evictor.victim(), Capacity)
+	}
+}
+
+// overflows reports whether storing a value of n bytes under key would take
+// the cache beyond its configured bounds. The caller must hold mu.
+func (c *Cache[K, V]) overflows(key K, n int64) bool {
+	entries, bytes := len(c.data), c.bytes+n
+	if e, ok := c.data[key]; ok {
+		bytes -= c.sizeOf(e.data)
+	} else {
+		entries++
+	}
+	return (c.maxEntries > 0 && entries > c.maxEntries) ||
+		(c.maxBytes > 0 && bytes > c.maxBytes)
+}
+
+// remove deletes e from the cache, the expiry heap and the evictor, and queues a
+// notification with the given reason. The caller must hold mu for writing.
+func (c *Cache[K, V]) remove(e *entry[K, V], reason EvictReason) {
+	c.stats.removed(reason)
+	c.notify(e, reason)
+	if e.index >= 0 {
+		heap.Remove(&c.expiries, e.index)
+	}
+	if c.evictor != nil {
+		c.evictor.remove(e)
+	}
+	c.bytes -= c.sizeOf(e.data)
+	delete(c.data, e.key)
+}
+
+// notify queues a notification that e's current value is leaving the cache. The
+// caller must hold mu for writing.
+func (c *Cache[K, V]) notify(e *entry[K, V], reason EvictReason) {
+	if c.onEvict != nil {
+		c.evicted = append(c.evicted, eviction[K, V]{key: e.key, value: e.data, reason: reason})
+	}
+}
+
+// unlock releases mu after a write and then delivers any notifications queued
+// while it was held.
+func (c *Cache[K, V]) unlock() {
+	evicted := c.evicted
+	c.evicted = nil
+	c.mu.Unlock()
+	for _, ev := range evicted {
+		c.onEvict(ev.key, ev.value, ev.reason)
+	}
+}
+
+// Close should be called after the Cache will no longer be used. It stops the
+// Cache's worker, or removes the Cache from its Janitor. If the Cache was
+// created WithSnapshotFile, Close writes a final snapshot and returns any error
+// from doing so. Calling Close more than once returns ErrClosed.
+//
+// Once the Cache is closed, Store, Snapshot and Restore return ErrClosed. The
+// other methods keep working on the data in memory, with expired keys removed
+// by later writes as they are WithoutJanitor, but nothing they write is
+// included in the snapshot.
+//
+// A Cache that becomes unreachable without being closed is closed by the
+// garbage collector, but Close should still be called to release the worker
+// promptly.
+func (c *Cache[K, V]) Close() error {
+	if !c.closed.CompareAndSwap(false, true) {
+		return ErrClosed
+	}
+	runtime.SetFinalizer(c, nil)
+	close(c.done)
+	if c.unregister != nil {
+		c.unregister()
+	}
+	c.mu.Lock()
+	c.lazy = true
+	c.mu.Unlock()
+	if c.snapshotPath != "" {
+		return c.snapshotFile(c.snapshotPath)
+	}
 	return nil
 }
+
+// expiryHeap is a min-heap of entries ordered by expiration. It implements
+// heap.Interface and keeps each entry's index up to date so that entries can
+// be rescheduled or removed in O(log n).
+type expiryHeap[K comparable, V any] []*entry[K, V]
+
+func (h expiryHeap[K, V]) Len() int           { return len(h) }
+func (h expiryHeap[K, V]) Less(i, j int) bool { return h[i].expiration.Before(h[j].expiration) }
+
+func (h expiryHeap[K, V]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap[K, V]) Push(x any) {
+	e := x.(*entry[K, V])
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *expiryHeap[K, V]) Pop() any {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	e.index = -1
+	*h = old[:n-1]
+	return e
+}
@@ -0,0 +1,350 @@
+package ttl
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGetSet(t *testing.T) {
+	m := NewMap(time.Minute)
+	defer m.Close()
+
+	if _, ok := m.Get("missing"); ok {
+		t.Fatal("want missing key to be absent")
+	}
+
+	m.Set("key", []byte("value"))
+	got, ok := m.Get("key")
+	if !ok {
+		t.Fatal("want key to be present")
+	}
+	if string(got) != "value" {
+		t.Fatalf("want %q, got %q", "value", got)
+	}
+}
+
+// TestConcurrentAccess exercises Get, Set and the expiry worker from many
+// goroutines at once. Run with -race to detect unsynchronized access.
+func TestConcurrentAccess(t *testing.T) {
+	m := NewMap(time.Millisecond)
+	defer m.Close()
+
+	const (
+		workers = 16
+		ops     = 1000
+	)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < ops; j++ {
+				key := fmt.Sprintf("key-%d", j%32)
+				if j%2 == 0 {
+					m.Set(key, []byte(key))
+					continue
+				}
+				if v, ok := m.Get(key); ok && string(v) != key {
+					t.Errorf("want %q, got %q", key, v)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// TestConcurrentExpiry calls removeExpired directly alongside readers and
+// writers so the race detector sees the expiry path without depending on the
+// ticker's timing.
+func TestConcurrentExpiry(t *testing.T) {
+	m := NewMap(time.Hour)
+	defer m.Close()
+	m.expiration = -time.Second // every entry is stale as soon as it is set
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					m.removeExpired()
+				}
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				key := fmt.Sprintf("key-%d-%d", i, j)
+				m.Set(key, []byte(key))
+				m.Get(key)
+			}
+		}(i)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+
+	m.removeExpired()
+	m.mu.RLock()
+	n, scheduled := len(m.data), len(m.expiries)
+	m.mu.RUnlock()
+	if n != 0 || scheduled != 0 {
+		t.Fatalf("want all entries expired, got %d remaining (%d scheduled)", n, scheduled)
+	}
+}
+
+func TestSetWithTTL(t *testing.T) {
+	m := NewMap(time.Hour)
+	defer m.Close()
+
+	m.Set("default", []byte("v"))
+	m.SetWithTTL("short", []byte("v"), -time.Second)
+	m.SetWithTTL("forever", []byte("v"), NoExpiration)
+
+	if d, ok := m.TTL("default"); !ok || d <= 59*time.Minute || d > time.Hour {
+		t.Fatalf("want default TTL near %v, got %v (present=%v)", time.Hour, d, ok)
+	}
+	if d, ok := m.TTL("forever"); !ok || d != NoExpiration {
+		t.Fatalf("want %v, got %v (present=%v)", NoExpiration, d, ok)
+	}
+	if _, ok := m.TTL("missing"); ok {
+		t.Fatal("want missing key to report no TTL")
+	}
+
+	m.removeExpired()
+
+	if _, ok := m.Get("short"); ok {
+		t.Fatal("want short-lived key to be removed")
+	}
+	for _, key := range []string{"default", "forever"} {
+		if _, ok := m.Get(key); !ok {
+			t.Fatalf("want %q to survive removeExpired", key)
+		}
+	}
+}
+
+func TestNoExpirationDefault(t *testing.T) {
+	m := NewMap(NoExpiration)
+	defer m.Close()
+
+	m.Set("key", []byte("v"))
+	m.removeExpired()
+	if d, ok := m.TTL("key"); !ok || d != NoExpiration {
+		t.Fatalf("want %v, got %v (present=%v)", NoExpiration, d, ok)
+	}
+}
+
+func TestRescheduleExpiry(t *testing.T) {
+	m := NewMap(time.Hour)
+	defer m.Close()
+
+	m.SetWithTTL("key", []byte("v"), -time.Second)
+	m.SetWithTTL("key", []byte("v"), time.Hour)
+	m.SetWithTTL("forever", []byte("v"), -time.Second)
+	m.SetWithTTL("forever", []byte("v"), NoExpiration)
+	m.removeExpired()
+
+	for _, key := range []string{"key", "forever"} {
+		if _, ok := m.Get(key); !ok {
+			t.Fatalf("want %q to be rescheduled and survive removeExpired", key)
+		}
+	}
+	if got := len(m.expiries); got != 1 {
+		t.Fatalf("want 1 scheduled entry, got %d", got)
+	}
+}
+
+// removeExpiredScan is the original expiry strategy, which visits every entry
+// in the map on each tick. It is kept here as a baseline for benchmarks, and
+// removes what it finds from the expiry heap too so the map stays consistent.
+func removeExpiredScan(m *Map) {
+	now := m.clock.Now()
+	m.mu.Lock()
+	defer m.unlock()
+	for _, e := range m.data {
+		if e.expired(now) {
+			m.remove(e, Expired)
+		}
+	}
+}
+
+// BenchmarkRemoveExpired compares a tick of the full-scan strategy with the
+// heap-driven one on maps where only a small fraction of keys are due.
+func BenchmarkRemoveExpired(b *testing.B) {
+	strategies := []struct {
+		name   string
+		remove func(*Map)
+	}{
+		{"scan", removeExpiredScan},
+		{"heap", (*Map).removeExpired},
+	}
+	for _, size := range []int{1000, 100000, 1000000} {
+		for _, s := range strategies {
+			b.Run(fmt.Sprintf("%s/%d", s.name, size), func(b *testing.B) {
+				// Each strategy gets a map of its own, so neither measures
+				// the other's leftovers.
+				m := NewMap(time.Hour)
+				defer m.Close()
+				for i := 0; i < size; i++ {
+					m.Set(strconv.Itoa(i), nil)
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					// Expire a single key per tick, as a steady trickle of
+					// expirations would in a busy map.
+					b.StopTimer()
+					m.SetWithTTL("due", nil, -time.Second)
+					b.StartTimer()
+					s.remove(m)
+				}
+				b.StopTimer()
+				if len(m.data) != size || len(m.expiries) != size {
+					b.Fatalf("want %d keys and heap entries, got %d and %d", size, len(m.data), len(m.expiries))
+				}
+			})
+		}
+	}
+}
+
+func TestGetExpiresLazily(t *testing.T) {
+	clock := NewFakeClock(time.Date(2018, 4, 10, 0, 0, 0, 0, time.UTC))
+	m := NewMap(time.Hour, WithClock(clock))
+	defer m.Close()
+
+	m.SetWithTTL("key", []byte("v"), time.Minute)
+
+	clock.Advance(time.Minute - time.Nanosecond)
+	if _, ok := m.Get("key"); !ok {
+		t.Fatal("want key to be present just before its expiration")
+	}
+	if d, ok := m.TTL("key"); !ok || d != time.Nanosecond {
+		t.Fatalf("want TTL of %v, got %v (present=%v)", time.Nanosecond, d, ok)
+	}
+
+	clock.Advance(time.Nanosecond)
+	if _, ok := m.Get("key"); ok {
+		t.Fatal("want key to be missing once its expiration is reached")
+	}
+	if _, ok := m.TTL("key"); ok {
+		t.Fatal("want expired key to report no TTL")
+	}
+	if n, scheduled := len(m.data), len(m.expiries); n != 0 || scheduled != 0 {
+		t.Fatalf("want Get to evict the expired key, got %d entries (%d scheduled)", n, scheduled)
+	}
+}
+
+func TestWorkerExpiresWithFakeClock(t *testing.T) {
+	clock := NewFakeClock(time.Date(2018, 4, 10, 0, 0, 0, 0, time.UTC))
+	m := NewMap(time.Minute, WithClock(clock))
+	defer m.Close()
+
+	m.Set("key", []byte("v"))
+	clock.Advance(time.Minute)
+
+	// The tick is delivered to the worker asynchronously, so wait for it to
+	// be handled rather than checking immediately.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		m.mu.RLock()
+		n := len(m.data)
+		m.mu.RUnlock()
+		if n == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want worker to remove expired key, got %d entries", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestKeyManagement(t *testing.T) {
+	clock := NewFakeClock(time.Date(2018, 4, 10, 0, 0, 0, 0, time.UTC))
+	m := NewMap(time.Minute, WithClock(clock))
+	defer m.Close()
+
+	m.Set("a", []byte("1"))
+	m.Set("b", []byte("2"))
+	m.SetWithTTL("c", []byte("3"), time.Second)
+
+	if got, loaded := m.GetOrSet("a", []byte("x")); !loaded || string(got) != "1" {
+		t.Fatalf("want loaded %q, got %q (loaded=%v)", "1", got, loaded)
+	}
+	if got, loaded := m.GetOrSet("d", []byte("4")); loaded || string(got) != "4" {
+		t.Fatalf("want stored %q, got %q (loaded=%v)", "4", got, loaded)
+	}
+	if m.CompareAndSwap("a", []byte("x"), []byte("y")) {
+		t.Fatal("want CompareAndSwap with the wrong old value to fail")
+	}
+	if !m.CompareAndSwap("a", []byte("1"), []byte("5")) {
+		t.Fatal("want CompareAndSwap with the current value to succeed")
+	}
+
+	clock.Advance(time.Second)
+	if got := m.Len(); got != 3 {
+		t.Fatalf("want 3 keys once c expires, got %d", got)
+	}
+	keys := m.Keys()
+	sort.Strings(keys)
+	if got, want := strings.Join(keys, ","), "a,b,d"; got != want {
+		t.Fatalf("want keys %v, got %v", want, got)
+	}
+
+	clock.Advance(50 * time.Second)
+	if !m.Touch("b") {
+		t.Fatal("want Touch to find b")
+	}
+	if m.TouchWithTTL("c", time.Hour) {
+		t.Fatal("want Touch to report expired key as missing")
+	}
+	clock.Advance(30 * time.Second)
+
+	got := map[string]string{}
+	m.Range(func(key string, value []byte) bool {
+		got[key] = string(value)
+		return true
+	})
+	if len(got) != 1 || got["b"] != "2" {
+		t.Fatalf("want only the touched key b=2 to remain, got %v", got)
+	}
+
+	if !m.Delete("b") || m.Delete("b") {
+		t.Fatal("want Delete to report presence exactly once")
+	}
+	m.Set("e", []byte("6"))
+	m.Clear()
+	if got := m.Len(); got != 0 {
+		t.Fatalf("want empty map after Clear, got %d keys", got)
+	}
+}
+
+func TestRangeStopsEarly(t *testing.T) {
+	m := NewMap(time.Hour)
+	defer m.Close()
+	for i := 0; i < 10; i++ {
+		m.Set(strconv.Itoa(i), nil)
+	}
+
+	calls := 0
+	m.Range(func(key string, value []byte) bool {
+		calls++
+		m.Delete(key) // Range must not hold the lock while calling fn.
+		return calls < 3
+	})
+	if calls != 3 {
+		t.Fatalf("want 3 calls, got %d", calls)
+	}
+	if got := m.Len(); got != 7 {
+		t.Fatalf("want 7 keys left, got %d", got)
+	}
+}